@@ -0,0 +1,133 @@
+package extproc
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Well-known envoy attribute names, as configured in the ext_proc filter's
+// request_attributes and response_attributes. See
+// https://www.envoyproxy.io/docs/envoy/latest/intro/arch_overview/advanced/attributes
+const (
+	AttrSourceAddress          = "source.address"
+	AttrSourcePort             = "source.port"
+	AttrDestinationAddress     = "destination.address"
+	AttrDestinationPort        = "destination.port"
+	AttrConnectionMTLS         = "connection.mtls"
+	AttrConnectionSNI          = "connection.requested_server_name"
+	AttrPeerSubject            = "connection.subject_peer_certificate"
+	AttrPeerURISAN             = "connection.uri_san_peer_certificate"
+	AttrPeerDNSSAN             = "connection.dns_san_peer_certificate"
+	AttrRequestTime            = "request.time"
+	AttrRequestProtocol        = "request.protocol"
+	AttrRouteName              = "xds.route_name"
+	AttrClusterName            = "xds.cluster_name"
+	AttrUpstreamAddress        = "upstream.address"
+	AttrResponseCode           = "response.code"
+	AttrResponseCodeDetails    = "response.code_details"
+	AttrResponseGrpcStatusCode = "response.grpc_status"
+)
+
+// mergeAttributes decodes the attributes envoy sends with a processing
+// request. Attributes are keyed by filter name and then attribute name;
+// we flatten across filters since the ext_proc filter only sends its own.
+func (rc *RequestContext) mergeAttributes(attrs map[string]*structpb.Struct) {
+	if len(attrs) == 0 {
+		return
+	}
+	if rc.attributes == nil {
+		rc.attributes = make(map[string]any)
+	}
+	for _, st := range attrs {
+		for name, val := range st.GetFields() {
+			rc.attributes[name] = val.AsInterface()
+		}
+	}
+}
+
+// Attribute returns the decoded value of an envoy attribute, if it was
+// sent. Values are typed as in structpb.Value.AsInterface (string,
+// float64, bool, []any, map[string]any or nil).
+func (rc *RequestContext) Attribute(name string) (any, bool) {
+	val, exists := rc.attributes[name]
+	return val, exists
+}
+
+// Attributes returns a copy of all attributes received so far in the
+// request stream.
+func (rc *RequestContext) Attributes() map[string]any {
+	attrs := make(map[string]any, len(rc.attributes))
+	for k, v := range rc.attributes {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// AttributeString returns a string attribute, or "" if it is not
+// defined or not a string.
+func (rc *RequestContext) AttributeString(name string) string {
+	val, _ := rc.attributes[name].(string)
+	return val
+}
+
+// AttributeBool returns a boolean attribute, or false if it is not
+// defined or not a boolean.
+func (rc *RequestContext) AttributeBool(name string) bool {
+	val, _ := rc.attributes[name].(bool)
+	return val
+}
+
+// AttributeInt returns a numeric attribute as an integer, and whether it
+// was defined as a number.
+func (rc *RequestContext) AttributeInt(name string) (int64, bool) {
+	val, ok := rc.attributes[name].(float64)
+	return int64(val), ok
+}
+
+// SourceAddress is the downstream peer address (as "ip:port"), taken from
+// the connection rather than any client-supplied header.
+func (rc *RequestContext) SourceAddress() string {
+	return rc.AttributeString(AttrSourceAddress)
+}
+
+// DestinationAddress is the local address the downstream connected to.
+func (rc *RequestContext) DestinationAddress() string {
+	return rc.AttributeString(AttrDestinationAddress)
+}
+
+// RouteName is the name of the envoy route matched for the request.
+func (rc *RequestContext) RouteName() string {
+	return rc.AttributeString(AttrRouteName)
+}
+
+// ClusterName is the name of the upstream cluster selected for the request.
+func (rc *RequestContext) ClusterName() string {
+	return rc.AttributeString(AttrClusterName)
+}
+
+// MTLS reports whether the downstream connection used mutual TLS.
+func (rc *RequestContext) MTLS() bool {
+	return rc.AttributeBool(AttrConnectionMTLS)
+}
+
+// PeerSubject is the subject of the downstream peer certificate (mTLS).
+func (rc *RequestContext) PeerSubject() string {
+	return rc.AttributeString(AttrPeerSubject)
+}
+
+// PeerURISAN is the first URI SAN of the downstream peer certificate
+// (e.g. a SPIFFE ID) when using mTLS.
+func (rc *RequestContext) PeerURISAN() string {
+	return rc.AttributeString(AttrPeerURISAN)
+}
+
+// RequestTime is the time envoy received the first byte of the request,
+// or the zero time if the attribute was not sent or cannot be parsed.
+func (rc *RequestContext) RequestTime() time.Time {
+	t, err := time.Parse(time.RFC3339Nano, rc.AttributeString(AttrRequestTime))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,146 @@
+package extproc_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+// rawStream serves processor in-process and starts a Process stream to
+// it, for tests sending processing requests the extproctest harness does
+// not build (attributes, metadata, ...).
+func rawStream(t *testing.T, processor ep.RequestProcessor, opts ...ep.Option) extprocv3.ExternalProcessor_ProcessClient {
+	t.Helper()
+	server, err := ep.NewServer(processor, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///raw",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamCtx, streamCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(func() {
+		streamCancel()
+		conn.Close()
+		cancel()
+		if err := <-served; err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			t.Errorf("server error: %v", err)
+		}
+	})
+
+	stream, err := extprocv3.NewExternalProcessorClient(conn).Process(streamCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+// exchange sends req on the stream and returns the response.
+func exchange(t *testing.T, stream extprocv3.ExternalProcessor_ProcessClient, req *extprocv3.ProcessingRequest) *extprocv3.ProcessingResponse {
+	t.Helper()
+	if err := stream.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func attributes(fields map[string]any) map[string]*structpb.Struct {
+	st, err := structpb.NewStruct(fields)
+	if err != nil {
+		panic(err)
+	}
+	return map[string]*structpb.Struct{"envoy.filters.http.ext_proc": st}
+}
+
+func TestAttributes(t *testing.T) {
+	var request, response map[string]any
+	stream := rawStream(t, &ep.ProcessorFuncs{
+		Name: "attributes",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			code, _ := ctx.AttributeInt(ep.AttrResponseCode)
+			request = map[string]any{
+				"source":  ctx.SourceAddress(),
+				"route":   ctx.RouteName(),
+				"mtls":    ctx.MTLS(),
+				"peer":    ctx.PeerURISAN(),
+				"time":    ctx.RequestTime(),
+				"code":    code,
+				"missing": ctx.AttributeString("missing"),
+			}
+			return ctx.ContinueRequest()
+		},
+		ResponseHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			code, ok := ctx.AttributeInt(ep.AttrResponseCode)
+			response = map[string]any{"code": code, "ok": ok, "source": ctx.SourceAddress()}
+			return ctx.ContinueRequest()
+		},
+	})
+
+	exchange(t, stream, &extprocv3.ProcessingRequest{
+		Request: &extprocv3.ProcessingRequest_RequestHeaders{RequestHeaders: &extprocv3.HttpHeaders{
+			Headers:     &corev3.HeaderMap{Headers: []*corev3.HeaderValue{{Key: ":method", RawValue: []byte("GET")}}},
+			EndOfStream: true,
+		}},
+		Attributes: attributes(map[string]any{
+			ep.AttrSourceAddress:  "10.0.0.1:4321",
+			ep.AttrRouteName:      "api",
+			ep.AttrConnectionMTLS: true,
+			ep.AttrPeerURISAN:     "spiffe://example.org/client",
+			ep.AttrRequestTime:    "2024-05-01T12:00:00.5Z",
+		}),
+	})
+	exchange(t, stream, &extprocv3.ProcessingRequest{
+		Request: &extprocv3.ProcessingRequest_ResponseHeaders{ResponseHeaders: &extprocv3.HttpHeaders{
+			Headers:     &corev3.HeaderMap{Headers: []*corev3.HeaderValue{{Key: ":status", RawValue: []byte("201")}}},
+			EndOfStream: true,
+		}},
+		Attributes: attributes(map[string]any{ep.AttrResponseCode: 201}),
+	})
+
+	want := map[string]any{
+		"source":  "10.0.0.1:4321",
+		"route":   "api",
+		"mtls":    true,
+		"peer":    "spiffe://example.org/client",
+		"time":    time.Date(2024, 5, 1, 12, 0, 0, 5e8, time.UTC),
+		"code":    int64(0),
+		"missing": "",
+	}
+	for k, v := range want {
+		if got := request[k]; got != v {
+			t.Errorf("request %s = %v, want %v", k, got, v)
+		}
+	}
+	// attributes accumulate over the stream
+	for k, v := range map[string]any{"code": int64(201), "ok": true, "source": "10.0.0.1:4321"} {
+		if got := response[k]; got != v {
+			t.Errorf("response %s = %v, want %v", k, got, v)
+		}
+	}
+}
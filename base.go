@@ -0,0 +1,171 @@
+package extproc
+
+// BaseProcessor implements every phase of RequestProcessor by continuing
+// the request unchanged. Embed it in a processor to only implement the
+// phases you care about (plus GetName and GetOptions):
+//
+//	type myProcessor struct {
+//		extproc.BaseProcessor
+//	}
+//
+//	func (p *myProcessor) ProcessRequestHeaders(ctx *extproc.RequestContext, headers extproc.Headers) error {
+//		...
+//	}
+//
+// Implement PhaseDeclarer to have the server skip the other phases.
+type BaseProcessor struct{}
+
+// PhaseDeclarer is implemented by processors that only process some
+// phases, e.g. ones embedding BaseProcessor, returning those phases as
+// REQUEST_PHASE_* constants:
+//
+//	func (p *myProcessor) ProcessedPhases() []int {
+//		return []int{extproc.REQUEST_PHASE_REQUEST_HEADERS}
+//	}
+//
+// The server checks it once, when created, and continues the request
+// unchanged in the other phases without calling the processor's phase
+// methods (lifecycle hooks still run). Body and trailers phases of a
+// processor implementing RequestBodyProcessor or ResponseBodyProcessor
+// are always processed, to buffer bodies. ProcessorFuncs and
+// ChainedProcessor declare their phases.
+type PhaseDeclarer interface {
+	ProcessedPhases() []int
+}
+
+// phaseSet flags phases by their REQUEST_PHASE_* constant.
+type phaseSet [REQUEST_PHASE_RESPONSE_TRAILERS + 1]bool
+
+// processedPhases returns the phases to call processor for: all of them,
+// unless it is a PhaseDeclarer.
+func processedPhases(processor RequestProcessor) phaseSet {
+	var phases phaseSet
+	d, ok := processor.(PhaseDeclarer)
+	if !ok {
+		for phase := range phases {
+			phases[phase] = true
+		}
+		return phases
+	}
+	for _, phase := range d.ProcessedPhases() {
+		if phase > REQUEST_PHASE_UNDETERMINED && phase <= REQUEST_PHASE_RESPONSE_TRAILERS {
+			phases[phase] = true
+		}
+	}
+	if _, ok := processor.(RequestBodyProcessor); ok {
+		phases[REQUEST_PHASE_REQUEST_BODY] = true
+		phases[REQUEST_PHASE_REQUEST_TRAILERS] = true
+	}
+	if _, ok := processor.(ResponseBodyProcessor); ok {
+		phases[REQUEST_PHASE_RESPONSE_BODY] = true
+		phases[REQUEST_PHASE_RESPONSE_TRAILERS] = true
+	}
+	return phases
+}
+
+func (BaseProcessor) ProcessRequestHeaders(ctx *RequestContext, headers Headers) error {
+	return ctx.ContinueRequest()
+}
+
+func (BaseProcessor) ProcessRequestBody(ctx *RequestContext, body []byte) error {
+	return ctx.ContinueRequest()
+}
+
+func (BaseProcessor) ProcessRequestTrailers(ctx *RequestContext, trailers Headers) error {
+	return ctx.ContinueRequest()
+}
+
+func (BaseProcessor) ProcessResponseHeaders(ctx *RequestContext, headers Headers) error {
+	return ctx.ContinueRequest()
+}
+
+func (BaseProcessor) ProcessResponseBody(ctx *RequestContext, body []byte) error {
+	return ctx.ContinueRequest()
+}
+
+func (BaseProcessor) ProcessResponseTrailers(ctx *RequestContext, trailers Headers) error {
+	return ctx.ContinueRequest()
+}
+
+// ProcessorFuncs is a RequestProcessor built from optional functions, one
+// per phase; phases without a function continue the request unchanged,
+// and are skipped by the server.
+type ProcessorFuncs struct {
+	Name    string
+	Options *ProcessingOptions
+
+	RequestHeaders   func(ctx *RequestContext, headers Headers) error
+	RequestBody      func(ctx *RequestContext, body []byte) error
+	RequestTrailers  func(ctx *RequestContext, trailers Headers) error
+	ResponseHeaders  func(ctx *RequestContext, headers Headers) error
+	ResponseBody     func(ctx *RequestContext, body []byte) error
+	ResponseTrailers func(ctx *RequestContext, trailers Headers) error
+}
+
+func (p *ProcessorFuncs) GetName() string {
+	return p.Name
+}
+
+func (p *ProcessorFuncs) GetOptions() *ProcessingOptions {
+	return p.Options
+}
+
+// ProcessedPhases declares the phases with a function.
+func (p *ProcessorFuncs) ProcessedPhases() []int {
+	var phases []int
+	for phase, set := range []bool{
+		REQUEST_PHASE_REQUEST_HEADERS:   p.RequestHeaders != nil,
+		REQUEST_PHASE_REQUEST_BODY:      p.RequestBody != nil,
+		REQUEST_PHASE_REQUEST_TRAILERS:  p.RequestTrailers != nil,
+		REQUEST_PHASE_RESPONSE_HEADERS:  p.ResponseHeaders != nil,
+		REQUEST_PHASE_RESPONSE_BODY:     p.ResponseBody != nil,
+		REQUEST_PHASE_RESPONSE_TRAILERS: p.ResponseTrailers != nil,
+	} {
+		if set {
+			phases = append(phases, phase)
+		}
+	}
+	return phases
+}
+
+func (p *ProcessorFuncs) ProcessRequestHeaders(ctx *RequestContext, headers Headers) error {
+	if p.RequestHeaders == nil {
+		return ctx.ContinueRequest()
+	}
+	return p.RequestHeaders(ctx, headers)
+}
+
+func (p *ProcessorFuncs) ProcessRequestBody(ctx *RequestContext, body []byte) error {
+	if p.RequestBody == nil {
+		return ctx.ContinueRequest()
+	}
+	return p.RequestBody(ctx, body)
+}
+
+func (p *ProcessorFuncs) ProcessRequestTrailers(ctx *RequestContext, trailers Headers) error {
+	if p.RequestTrailers == nil {
+		return ctx.ContinueRequest()
+	}
+	return p.RequestTrailers(ctx, trailers)
+}
+
+func (p *ProcessorFuncs) ProcessResponseHeaders(ctx *RequestContext, headers Headers) error {
+	if p.ResponseHeaders == nil {
+		return ctx.ContinueRequest()
+	}
+	return p.ResponseHeaders(ctx, headers)
+}
+
+func (p *ProcessorFuncs) ProcessResponseBody(ctx *RequestContext, body []byte) error {
+	if p.ResponseBody == nil {
+		return ctx.ContinueRequest()
+	}
+	return p.ResponseBody(ctx, body)
+}
+
+func (p *ProcessorFuncs) ProcessResponseTrailers(ctx *RequestContext, trailers Headers) error {
+	if p.ResponseTrailers == nil {
+		return ctx.ContinueRequest()
+	}
+	return p.ResponseTrailers(ctx, trailers)
+}
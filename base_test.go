@@ -0,0 +1,116 @@
+package extproc_test
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// tagging only tags requests with an x-tag header, delegating the others
+// to BaseProcessor, and declares the request headers phase.
+type tagging struct {
+	ep.BaseProcessor
+
+	mu     sync.Mutex
+	phases []int // phases seen by the preprocessing hook
+	called []int // phase methods called
+}
+
+func (p *tagging) GetName() string                   { return "tagging" }
+func (p *tagging) GetOptions() *ep.ProcessingOptions { return nil }
+func (p *tagging) ProcessedPhases() []int            { return []int{ep.REQUEST_PHASE_REQUEST_HEADERS} }
+
+func (p *tagging) record(list *[]int, phase int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	*list = append(*list, phase)
+}
+
+func (p *tagging) PreprocessPhase(ctx *ep.RequestContext, phase int) error {
+	p.record(&p.phases, phase)
+	return nil
+}
+
+func (p *tagging) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
+	p.record(&p.called, ep.REQUEST_PHASE_REQUEST_HEADERS)
+	if tag := headers.Get("x-tag"); tag != "" {
+		ctx.OverwriteHeader("x-tagged", ep.HeaderValue{RawValue: []byte(tag)})
+		return ctx.ContinueRequest()
+	}
+	return p.BaseProcessor.ProcessRequestHeaders(ctx, headers)
+}
+
+// not declared, so never called
+func (p *tagging) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
+	p.record(&p.called, ep.REQUEST_PHASE_RESPONSE_HEADERS)
+	ctx.OverwriteHeader("x-tagged", ep.HeaderValue{RawValue: []byte("response")})
+	return ctx.ContinueRequest()
+}
+
+func TestDeclaredPhasesOnly(t *testing.T) {
+	p := &tagging{}
+	h := extproctest.New(t, p)
+
+	// delegating for some requests must not stop the phase from running
+	// for later ones
+	h.Request("GET", "/").Response(200).Run().AssertNoHeader(ep.REQUEST_PHASE_REQUEST_HEADERS, "x-tagged")
+	res := h.Request("GET", "/").Header("x-tag", "blue").Body("data").Response(200).Run()
+	res.AssertContinued()
+	res.AssertUpstreamHeader("x-tagged", "blue")
+	res.AssertNoHeader(ep.REQUEST_PHASE_RESPONSE_HEADERS, "x-tagged")
+
+	want := []int{ep.REQUEST_PHASE_REQUEST_HEADERS, ep.REQUEST_PHASE_REQUEST_HEADERS}
+	if !slices.Equal(p.called, want) {
+		t.Errorf("called phases %v, want %v", p.called, want)
+	}
+	// hooks still run in skipped phases
+	want = []int{
+		ep.REQUEST_PHASE_REQUEST_HEADERS, ep.REQUEST_PHASE_RESPONSE_HEADERS,
+		ep.REQUEST_PHASE_REQUEST_HEADERS, ep.REQUEST_PHASE_REQUEST_BODY, ep.REQUEST_PHASE_RESPONSE_HEADERS,
+	}
+	if !slices.Equal(p.phases, want) {
+		t.Errorf("preprocessed phases %v, want %v", p.phases, want)
+	}
+}
+
+func TestProcessedPhases(t *testing.T) {
+	set := func(ctx *ep.RequestContext, headers ep.Headers) error { return ctx.ContinueRequest() }
+	body := func(ctx *ep.RequestContext, body []byte) error { return ctx.ContinueRequest() }
+	request := &ep.ProcessorFuncs{Name: "request", RequestHeaders: set, RequestBody: body}
+	response := &ep.ProcessorFuncs{Name: "response", ResponseTrailers: set}
+
+	tests := []struct {
+		name      string
+		processor ep.PhaseDeclarer
+		want      []int
+	}{
+		{"funcs", request, []int{ep.REQUEST_PHASE_REQUEST_HEADERS, ep.REQUEST_PHASE_REQUEST_BODY}},
+		{"empty funcs", &ep.ProcessorFuncs{}, nil},
+		{"chain", ep.Chain(request, response), []int{
+			ep.REQUEST_PHASE_REQUEST_HEADERS, ep.REQUEST_PHASE_REQUEST_BODY, ep.REQUEST_PHASE_RESPONSE_TRAILERS,
+		}},
+		{"chain with undeclared", ep.Chain(response, &tagging{}, &undeclared{}), []int{
+			ep.REQUEST_PHASE_REQUEST_HEADERS, ep.REQUEST_PHASE_REQUEST_BODY, ep.REQUEST_PHASE_REQUEST_TRAILERS,
+			ep.REQUEST_PHASE_RESPONSE_HEADERS, ep.REQUEST_PHASE_RESPONSE_BODY, ep.REQUEST_PHASE_RESPONSE_TRAILERS,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.processor.ProcessedPhases(); !slices.Equal(got, tt.want) {
+				t.Errorf("processed phases %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// undeclared embeds BaseProcessor without declaring phases, so it is
+// called for all of them.
+type undeclared struct {
+	ep.BaseProcessor
+}
+
+func (p *undeclared) GetName() string                   { return "undeclared" }
+func (p *undeclared) GetOptions() *ep.ProcessingOptions { return nil }
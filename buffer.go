@@ -0,0 +1,211 @@
+package extproc
+
+import (
+	"fmt"
+	"net/http"
+
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+// default bound on buffered bodies, per direction
+const kDefaultMaxBufferedBody = 1 << 20
+
+// BodyOverflow selects what happens when a buffered body grows beyond
+// BodyBufferOptions.MaxSize.
+type BodyOverflow int
+
+const (
+	// RejectOverflow responds with 413 (request bodies) or 500 (response
+	// bodies) as an HTTPError.
+	RejectOverflow BodyOverflow = iota
+	// PassOverflow stops buffering and sends the body on unprocessed: the
+	// buffered data replaces the current chunk and later chunks continue
+	// unchanged. The complete body handler is not called.
+	PassOverflow
+)
+
+// BodyBufferOptions enable buffering of streamed bodies for processors
+// implementing RequestBodyProcessor and/or ResponseBodyProcessor.
+type BodyBufferOptions struct {
+	// MaxSize bounds the buffered body, in bytes (1MiB if not positive)
+	MaxSize  int
+	Overflow BodyOverflow
+}
+
+// RequestBodyProcessor can be implemented by a RequestProcessor to get
+// the whole request body in one call when ProcessingOptions.BodyBuffer is
+// set, even if envoy streams it in chunks (STREAMED body mode). It is
+// called instead of ProcessRequestBody, once the body ends, and the body
+// can be replaced or cleared as a whole with ReplaceBodyChunk and
+// ClearBodyChunk. Earlier chunks are held back from the upstream.
+//
+// Bodies ending with trailers never see an end of stream in a body phase,
+// so they are completed in the trailers phase (if envoy sends it), before
+// ProcessRequestTrailers. Only FULL_DUPLEX_STREAMED bodies can still be
+// sent then; in STREAMED mode the held back chunks are lost, which is
+// handled as an error after the call. BUFFERED bodies are complete in
+// their body phase.
+type RequestBodyProcessor interface {
+	ProcessRequestBodyComplete(ctx *RequestContext, body []byte) error
+}
+
+// ResponseBodyProcessor is the response counterpart of RequestBodyProcessor.
+type ResponseBodyProcessor interface {
+	ProcessResponseBodyComplete(ctx *RequestContext, body []byte) error
+}
+
+// bodyBuffer accumulates one direction's body chunks across phases.
+type bodyBuffer struct {
+	data       []byte
+	pending    bool // chunks held back, body not completed
+	overflowed bool
+}
+
+// bodyCompletion returns the handler of the whole body for the body phase
+// (or the body of a trailers phase's direction), or nil if the processor
+// does not buffer it.
+func (s *GenericExtProcServer) bodyCompletion(rc *RequestContext, processor RequestProcessor, phase int) func([]byte) error {
+	if s.options.BodyBuffer == nil {
+		return nil
+	}
+	var complete func(*RequestContext, []byte) error
+	if bp, ok := processor.(RequestBodyProcessor); ok && phase == REQUEST_PHASE_REQUEST_BODY {
+		complete = bp.ProcessRequestBodyComplete
+	}
+	if bp, ok := processor.(ResponseBodyProcessor); ok && phase == REQUEST_PHASE_RESPONSE_BODY {
+		complete = bp.ProcessResponseBodyComplete
+	}
+	if complete == nil {
+		return nil
+	}
+	return func(body []byte) error {
+		body, err := rc.decodeBody(body)
+		if err != nil {
+			return err
+		}
+		return complete(rc, body)
+	}
+}
+
+// bodyBuffer returns the buffer of the phase's direction.
+func (rc *RequestContext) bodyBuffer() *bodyBuffer {
+	switch rc.phase {
+	case REQUEST_PHASE_RESPONSE_HEADERS, REQUEST_PHASE_RESPONSE_BODY, REQUEST_PHASE_RESPONSE_TRAILERS:
+		return &rc.responseBody
+	}
+	return &rc.requestBody
+}
+
+// wholeBodyMessage reports whether the body phase gets the whole body in
+// a single message (BUFFERED body mode), even if trailers follow.
+func (rc *RequestContext) wholeBodyMessage() bool {
+	if rc.mode == nil {
+		return false
+	}
+	mode := rc.mode.RequestBodyMode
+	if rc.phase == REQUEST_PHASE_RESPONSE_BODY {
+		mode = rc.mode.ResponseBodyMode
+	}
+	return mode == filterv3.ProcessingMode_BUFFERED
+}
+
+// bufferBody buffers a body chunk, calling complete with the whole body
+// at the end of the stream. Interim chunks are cleared, and the final
+// chunk replaced with the whole body unless the handler mutates it.
+func (s *GenericExtProcServer) bufferBody(rc *RequestContext, chunk []byte, complete func([]byte) error) error {
+	buf := rc.bodyBuffer()
+	if buf.overflowed {
+		return rc.ContinueRequest()
+	}
+
+	maxSize := s.options.BodyBuffer.MaxSize
+	if maxSize <= 0 {
+		maxSize = kDefaultMaxBufferedBody
+	}
+	if len(buf.data)+len(chunk) > maxSize {
+		rc.Logger().Warn("buffered body too large", "phase", PhaseName(rc.phase), "max_size", maxSize)
+		if s.options.BodyBuffer.Overflow == PassOverflow {
+			buf.overflowed = true
+			if len(buf.data) > 0 {
+				rc.replaceBody(append(buf.data, chunk...))
+			}
+			buf.data, buf.pending = nil, false
+			return rc.ContinueRequest()
+		}
+		status := int32(http.StatusRequestEntityTooLarge)
+		if rc.phase == REQUEST_PHASE_RESPONSE_BODY {
+			status = http.StatusInternalServerError
+		}
+		return NewHTTPError(status, fmt.Sprintf("body exceeds %d bytes", maxSize))
+	}
+
+	buf.data = append(buf.data, chunk...)
+	if !rc.EndOfStream && !rc.wholeBodyMessage() {
+		buf.pending = true
+		return rc.ClearBodyChunk()
+	}
+
+	body := buf.data
+	buf.data, buf.pending = nil, false
+	if err := complete(body); err != nil || rc.cancelled() {
+		return err
+	}
+	if rc.response.bodyMutation == nil && len(rc.response.streamedBody) == 0 && len(body) != len(chunk) {
+		rc.replaceBody(body)
+	}
+	return rc.ContinueRequest()
+}
+
+// completeBufferedBody calls complete, in a trailers phase, with a body
+// held back by bufferBody that never saw the end of the stream. In
+// FULL_DUPLEX_STREAMED mode the (possibly replaced) body is sent ahead of
+// the trailers; otherwise the held back chunks can no longer be sent and
+// an error is returned for the ErrorPolicy.
+func (s *GenericExtProcServer) completeBufferedBody(rc *RequestContext, complete func([]byte) error) error {
+	buf := rc.bodyBuffer()
+	if !buf.pending {
+		return nil
+	}
+	body := buf.data
+	buf.data, buf.pending = nil, false
+	if err := complete(body); err != nil {
+		return err
+	}
+
+	mutation := rc.response.bodyMutation
+	rc.response.bodyMutation, rc.response.plainBody = nil, nil
+	if !rc.FullDuplexBody(rc.phase) {
+		return fmt.Errorf("buffered body ended with trailers, %d held back bytes were not sent", len(body))
+	}
+	switch m := mutation.GetMutation().(type) {
+	case *extprocv3.BodyMutation_Body:
+		body = m.Body
+	case *extprocv3.BodyMutation_ClearBody:
+		body = nil
+	}
+	rc.response.streamedBody = append(rc.response.streamedBody, &extprocv3.StreamedBodyResponse{Body: body})
+	return nil
+}
+
+// ChainedProcessor buffers bodies for all chained processors, calling
+// ProcessRequestBodyComplete for those implementing RequestBodyProcessor
+// and ProcessRequestBody (with the whole body) for the others.
+
+func (c *ChainedProcessor) ProcessRequestBodyComplete(ctx *RequestContext, body []byte) error {
+	return c.forwardBody(ctx, body, func(p RequestProcessor, b []byte) error {
+		if bp, ok := p.(RequestBodyProcessor); ok {
+			return bp.ProcessRequestBodyComplete(ctx, b)
+		}
+		return p.ProcessRequestBody(ctx, b)
+	})
+}
+
+func (c *ChainedProcessor) ProcessResponseBodyComplete(ctx *RequestContext, body []byte) error {
+	return c.reverseBody(ctx, body, func(p RequestProcessor, b []byte) error {
+		if bp, ok := p.(ResponseBodyProcessor); ok {
+			return bp.ProcessResponseBodyComplete(ctx, b)
+		}
+		return p.ProcessResponseBody(ctx, b)
+	})
+}
@@ -0,0 +1,142 @@
+package extproc_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// bufferingProcessor records the complete request bodies it gets, and
+// upper cases them if upper is set.
+type bufferingProcessor struct {
+	ep.BaseProcessor
+	options *ep.ProcessingOptions
+	upper   bool
+	bodies  []string
+}
+
+func (p *bufferingProcessor) GetName() string                   { return "buffering" }
+func (p *bufferingProcessor) GetOptions() *ep.ProcessingOptions { return p.options }
+
+func (p *bufferingProcessor) ProcessRequestBodyComplete(ctx *ep.RequestContext, body []byte) error {
+	p.bodies = append(p.bodies, string(body))
+	if p.upper {
+		return ctx.ReplaceBodyChunk(bytes.ToUpper(body))
+	}
+	return ctx.ContinueRequest()
+}
+
+func TestBufferBody(t *testing.T) {
+	for _, upper := range []bool{false, true} {
+		p := &bufferingProcessor{
+			options: &ep.ProcessingOptions{BodyBuffer: &ep.BodyBufferOptions{}},
+			upper:   upper,
+		}
+		res := extproctest.New(t, p).Request("POST", "/").Body("ab", "cd", "ef").Run()
+
+		res.AssertContinued()
+		if len(p.bodies) != 1 || p.bodies[0] != "abcdef" {
+			t.Errorf("complete bodies %q, want one \"abcdef\"", p.bodies)
+		}
+		want := "abcdef"
+		if upper {
+			want = "ABCDEF"
+		}
+		res.AssertBody(ep.REQUEST_PHASE_REQUEST_BODY, want)
+		res.AssertUpstreamBody(want)
+	}
+}
+
+func TestBufferBodyOverflow(t *testing.T) {
+	t.Run("reject", func(t *testing.T) {
+		p := &bufferingProcessor{options: &ep.ProcessingOptions{
+			BodyBuffer: &ep.BodyBufferOptions{MaxSize: 4},
+		}}
+		res := extproctest.New(t, p).Request("POST", "/").Body("ab", "cd", "ef").Run()
+
+		res.AssertImmediateResponse(413)
+		if len(p.bodies) != 0 {
+			t.Errorf("complete handler called with %q", p.bodies)
+		}
+	})
+
+	t.Run("pass", func(t *testing.T) {
+		p := &bufferingProcessor{options: &ep.ProcessingOptions{
+			BodyBuffer: &ep.BodyBufferOptions{MaxSize: 4, Overflow: ep.PassOverflow},
+		}}
+		res := extproctest.New(t, p).Request("POST", "/").Body("ab", "cd", "ef", "gh").Run()
+
+		res.AssertContinued()
+		if len(p.bodies) != 0 {
+			t.Errorf("complete handler called with %q", p.bodies)
+		}
+		res.AssertUpstreamBody("abcdefgh")
+	})
+}
+
+func TestBufferBodyWithTrailers(t *testing.T) {
+	mode := func(body filterv3.ProcessingMode_BodySendMode) *filterv3.ProcessingMode {
+		return &filterv3.ProcessingMode{RequestBodyMode: body, RequestTrailerMode: filterv3.ProcessingMode_SEND}
+	}
+
+	t.Run("full duplex", func(t *testing.T) {
+		p := &bufferingProcessor{upper: true, options: &ep.ProcessingOptions{
+			BodyBuffer:     &ep.BodyBufferOptions{},
+			ProcessingMode: mode(filterv3.ProcessingMode_FULL_DUPLEX_STREAMED),
+		}}
+		res := extproctest.New(t, p).Request("POST", "/").Body("ab", "cd").Trailer("x-checksum", "abc").Run()
+
+		res.AssertNoError()
+		if len(p.bodies) != 1 || p.bodies[0] != "abcd" {
+			t.Errorf("complete bodies %q, want one \"abcd\"", p.bodies)
+		}
+		res.AssertUpstreamBody("ABCD")
+		if got := res.Upstream().Trailers.Get("x-checksum"); got != "abc" {
+			t.Errorf("upstream trailer x-checksum %q, want abc", got)
+		}
+	})
+
+	t.Run("buffered", func(t *testing.T) {
+		p := &bufferingProcessor{upper: true, options: &ep.ProcessingOptions{
+			BodyBuffer:     &ep.BodyBufferOptions{},
+			ProcessingMode: mode(filterv3.ProcessingMode_BUFFERED),
+		}}
+		res := extproctest.New(t, p).Request("POST", "/").Body("abcd").Trailer("x-checksum", "abc").Run()
+
+		res.AssertContinued()
+		if len(p.bodies) != 1 || p.bodies[0] != "abcd" {
+			t.Errorf("complete bodies %q, want one \"abcd\"", p.bodies)
+		}
+		res.AssertUpstreamBody("ABCD")
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		var reported error
+		p := &bufferingProcessor{options: &ep.ProcessingOptions{
+			BodyBuffer:     &ep.BodyBufferOptions{},
+			ProcessingMode: mode(filterv3.ProcessingMode_STREAMED),
+			ErrorPolicy: func(ctx *ep.RequestContext, phase int, err error) error {
+				reported = err
+				return ep.TerminateOnError(codes.Internal)(ctx, phase, err)
+			},
+		}}
+		res := extproctest.New(t, p).Request("POST", "/").Body("ab", "cd").Trailer("x-checksum", "abc").Run()
+
+		if len(p.bodies) != 1 || p.bodies[0] != "abcd" {
+			t.Errorf("complete bodies %q, want one \"abcd\"", p.bodies)
+		}
+		if reported == nil || !strings.Contains(reported.Error(), "4 held back bytes") {
+			t.Errorf("reported error %v, want the held back body", reported)
+		}
+		if status.Code(res.Err) != codes.Internal {
+			t.Errorf("stream error %v, want code Internal", res.Err)
+		}
+	})
+}
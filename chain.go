@@ -0,0 +1,249 @@
+package extproc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+// ChainedProcessor runs several RequestProcessors inside a single
+// ExtProc, as if they were stacked ExtProcs in the envoy filter chain.
+// Request phases run processors in the order given, response phases
+// run them in reverse order. All processors share one RequestContext,
+// so header and body mutations from each processor are merged into the
+// single response sent back to envoy, as is any data set with SetValue
+// (processors in a chain should use distinct keys). Each processor gets
+// the headers (or trailers) and body as changed by the processors before
+// it in the phase; ctx.Headers holds the request headers as received.
+//
+// A chain stops early when a processor returns an error or calls
+// CancelRequest; the remaining processors are not called for that phase.
+// As with stacked ExtProcs, the immediate response of a processor calling
+// CancelRequest only has the headers that processor set, not those set
+// for the upstream by the processors before it.
+type ChainedProcessor struct {
+	processors []RequestProcessor
+	options    *ProcessingOptions
+}
+
+// Chain composes processors into a single RequestProcessor. Options are
+// taken from the first processor that defines them, unless overridden
+// with WithOptions.
+func Chain(processors ...RequestProcessor) *ChainedProcessor {
+	c := &ChainedProcessor{}
+	for _, p := range processors {
+		if p != nil {
+			c.processors = append(c.processors, p)
+		}
+	}
+	return c
+}
+
+// WithOptions sets the ProcessingOptions used for the whole chain.
+func (c *ChainedProcessor) WithOptions(opts *ProcessingOptions) *ChainedProcessor {
+	c.options = opts
+	return c
+}
+
+// Processors returns the chained processors in request phase order.
+func (c *ChainedProcessor) Processors() []RequestProcessor {
+	return c.processors
+}
+
+func (c *ChainedProcessor) GetName() string {
+	names := make([]string, 0, len(c.processors))
+	for _, p := range c.processors {
+		names = append(names, p.GetName())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *ChainedProcessor) GetOptions() *ProcessingOptions {
+	if c.options != nil {
+		return c.options
+	}
+	for _, p := range c.processors {
+		if opts := p.GetOptions(); opts != nil {
+			return opts
+		}
+	}
+	return nil
+}
+
+// HealthCheck checks every chained processor that implements HealthChecker.
+func (c *ChainedProcessor) HealthCheck(ctx context.Context) error {
+	for _, p := range c.processors {
+		if hc, ok := p.(HealthChecker); ok {
+			if err := hc.HealthCheck(ctx); err != nil {
+				return fmt.Errorf("%s: %w", p.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// ProcessedPhases declares the phases processed by any of the chained
+// processors.
+func (c *ChainedProcessor) ProcessedPhases() []int {
+	var phases []int
+	for phase := REQUEST_PHASE_REQUEST_HEADERS; phase <= REQUEST_PHASE_RESPONSE_TRAILERS; phase++ {
+		for _, p := range c.processors {
+			if processedPhases(p)[phase] {
+				phases = append(phases, phase)
+				break
+			}
+		}
+	}
+	return phases
+}
+
+func (c *ChainedProcessor) ProcessRequestHeaders(ctx *RequestContext, headers Headers) error {
+	return c.forward(ctx, func(p RequestProcessor) error {
+		return p.ProcessRequestHeaders(ctx, headers.mutated(ctx.response.headerMutation))
+	})
+}
+
+func (c *ChainedProcessor) ProcessRequestBody(ctx *RequestContext, body []byte) error {
+	return c.forwardBody(ctx, body, func(p RequestProcessor, b []byte) error {
+		return p.ProcessRequestBody(ctx, b)
+	})
+}
+
+func (c *ChainedProcessor) ProcessRequestTrailers(ctx *RequestContext, trailers Headers) error {
+	return c.forward(ctx, func(p RequestProcessor) error {
+		return p.ProcessRequestTrailers(ctx, trailers.mutated(ctx.response.headerMutation))
+	})
+}
+
+func (c *ChainedProcessor) ProcessResponseHeaders(ctx *RequestContext, headers Headers) error {
+	return c.reverse(ctx, func(p RequestProcessor) error {
+		return p.ProcessResponseHeaders(ctx, headers.mutated(ctx.response.headerMutation))
+	})
+}
+
+func (c *ChainedProcessor) ProcessResponseBody(ctx *RequestContext, body []byte) error {
+	return c.reverseBody(ctx, body, func(p RequestProcessor, b []byte) error {
+		return p.ProcessResponseBody(ctx, b)
+	})
+}
+
+func (c *ChainedProcessor) ProcessResponseTrailers(ctx *RequestContext, trailers Headers) error {
+	return c.reverse(ctx, func(p RequestProcessor) error {
+		return p.ProcessResponseTrailers(ctx, trailers.mutated(ctx.response.headerMutation))
+	})
+}
+
+func (c *ChainedProcessor) forward(ctx *RequestContext, call func(RequestProcessor) error) error {
+	return c.each(ctx, c.processors, call)
+}
+
+func (c *ChainedProcessor) reverse(ctx *RequestContext, call func(RequestProcessor) error) error {
+	reversed := make([]RequestProcessor, len(c.processors))
+	for i, p := range c.processors {
+		reversed[len(c.processors)-1-i] = p
+	}
+	return c.each(ctx, reversed, call)
+}
+
+// each calls the processors in order, stopping at errors or cancellation.
+// A processor cancelling the request replies with its own headers only,
+// not those set for the upstream by the processors before it.
+func (c *ChainedProcessor) each(ctx *RequestContext, processors []RequestProcessor, call func(RequestProcessor) error) error {
+	for _, p := range processors {
+		hm := ctx.response.headerMutation
+		set, removed := slices.Clone(hm.SetHeaders), slices.Clone(hm.RemoveHeaders)
+		if err := call(p); err != nil {
+			return err
+		}
+		if ctx.cancelled() {
+			ctx.response.immediateResponse.Headers = headersAddedSince(ctx.response.headerMutation, set, removed)
+			break
+		}
+	}
+	return nil
+}
+
+// headersAddedSince returns the mutations of hm that are not among the
+// set and removed headers of an earlier snapshot.
+func headersAddedSince(hm *extprocv3.HeaderMutation, set []*corev3.HeaderValueOption, removed []string) *extprocv3.HeaderMutation {
+	added := &extprocv3.HeaderMutation{}
+	for _, h := range hm.GetSetHeaders() {
+		if !slices.Contains(set, h) {
+			added.SetHeaders = append(added.SetHeaders, h)
+		}
+	}
+	for _, name := range hm.GetRemoveHeaders() {
+		if !slices.Contains(removed, name) {
+			added.RemoveHeaders = append(added.RemoveHeaders, name)
+		}
+	}
+	return added
+}
+
+// forwardBody and reverseBody hand each processor the body as mutated
+// by the processors before it, so replacements compose.
+func (c *ChainedProcessor) forwardBody(ctx *RequestContext, body []byte, call func(RequestProcessor, []byte) error) error {
+	return c.forward(ctx, func(p RequestProcessor) error {
+		return ctx.chainBody(&body, func() error { return call(p, body) })
+	})
+}
+
+func (c *ChainedProcessor) reverseBody(ctx *RequestContext, body []byte, call func(RequestProcessor, []byte) error) error {
+	return c.reverse(ctx, func(p RequestProcessor) error {
+		return ctx.chainBody(&body, func() error { return call(p, body) })
+	})
+}
+
+// chainBody calls a processor with the body and updates it to the body as
+// mutated. Chunks emitted by the processor replace those emitted before
+// it, since it was given their contents as body.
+func (rc *RequestContext) chainBody(body *[]byte, call func() error) error {
+	emitted := len(rc.response.streamedBody)
+	if err := call(); err != nil {
+		return err
+	}
+	if emitted > 0 && len(rc.response.streamedBody) > emitted {
+		rc.response.streamedBody = rc.response.streamedBody[emitted:]
+	}
+	if rc.response.plainBody != nil {
+		// a decoded body was replaced, pass on the plaintext
+		*body = rc.response.plainBody
+	} else {
+		*body = rc.mutatedBody(*body)
+	}
+	return nil
+}
+
+// cancelled reports whether a processor has defined an immediate
+// response in the current phase.
+func (rc *RequestContext) cancelled() bool {
+	return rc.response.immediateResponse != nil
+}
+
+// mutatedBody returns the body chunk as it will be seen by envoy after
+// the current body mutation (if any) is applied.
+func (rc *RequestContext) mutatedBody(body []byte) []byte {
+	if len(rc.response.streamedBody) > 0 {
+		var emitted []byte
+		for _, chunk := range rc.response.streamedBody {
+			emitted = append(emitted, chunk.Body...)
+		}
+		return emitted
+	}
+	if rc.response.bodyMutation == nil {
+		return body
+	}
+	switch m := rc.response.bodyMutation.Mutation.(type) {
+	case *extprocv3.BodyMutation_Body:
+		return m.Body
+	case *extprocv3.BodyMutation_ClearBody:
+		if m.ClearBody {
+			return nil
+		}
+	}
+	return body
+}
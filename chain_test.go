@@ -0,0 +1,200 @@
+package extproc_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// link is a chained processor recording its calls in a shared log, and
+// setting a header with its name in every headers phase.
+func link(name string, log *[]string) *ep.ProcessorFuncs {
+	headers := func(phase string) func(*ep.RequestContext, ep.Headers) error {
+		return func(ctx *ep.RequestContext, headers ep.Headers) error {
+			*log = append(*log, name+":"+phase)
+			return ctx.AppendHeader("x-chain", ep.HeaderValue{RawValue: []byte(name)})
+		}
+	}
+	return &ep.ProcessorFuncs{
+		Name:            name,
+		RequestHeaders:  headers("request_headers"),
+		ResponseHeaders: headers("response_headers"),
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var log []string
+	h := extproctest.New(t, ep.Chain(link("a", &log), link("b", &log), link("c", &log)))
+	res := h.Request("GET", "/").Response(200).Run()
+
+	res.AssertContinued()
+	want := []string{
+		"a:request_headers", "b:request_headers", "c:request_headers",
+		"c:response_headers", "b:response_headers", "a:response_headers",
+	}
+	if !slices.Equal(log, want) {
+		t.Errorf("calls %v, want %v", log, want)
+	}
+	res.AssertUpstreamHeader("x-chain", "a", "b", "c")
+	res.AssertDownstreamHeader("x-chain", "c", "b", "a")
+}
+
+func TestChainShortCircuit(t *testing.T) {
+	tests := []struct {
+		name   string
+		second func(ctx *ep.RequestContext, headers ep.Headers) error
+		check  func(t *testing.T, res *extproctest.Result)
+	}{
+		{
+			name: "cancel",
+			second: func(ctx *ep.RequestContext, headers ep.Headers) error {
+				return ctx.CancelRequest(401, nil, "")
+			},
+			check: func(t *testing.T, res *extproctest.Result) {
+				res.AssertImmediateResponse(401)
+			},
+		},
+		{
+			name: "error",
+			second: func(ctx *ep.RequestContext, headers ep.Headers) error {
+				return ep.NewHTTPError(503, "unavailable")
+			},
+			check: func(t *testing.T, res *extproctest.Result) {
+				res.AssertImmediateResponse(503)
+				res.AssertDownstreamBody("unavailable")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log []string
+			second := &ep.ProcessorFuncs{
+				Name: "second",
+				RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+					log = append(log, "second")
+					return tt.second(ctx, headers)
+				},
+			}
+			h := extproctest.New(t, ep.Chain(link("first", &log), second, link("third", &log)))
+			res := h.Request("GET", "/").Response(200).Run()
+
+			res.AssertNoError()
+			tt.check(t, res)
+			if want := []string{"first:request_headers", "second"}; !slices.Equal(log, want) {
+				t.Errorf("calls %v, want %v", log, want)
+			}
+			if len(res.Phases) != 1 {
+				t.Errorf("got %d phases, want the exchange to end at request headers", len(res.Phases))
+			}
+		})
+	}
+}
+
+func TestChainCancelRepliesWithOwnHeaders(t *testing.T) {
+	upstream := &ep.ProcessorFuncs{
+		Name: "upstream",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			ctx.OverwriteHeader("x-internal-upstream", ep.HeaderValue{RawValue: []byte("secret")})
+			return ctx.RemoveHeader("cookie")
+		},
+	}
+	deny := &ep.ProcessorFuncs{
+		Name: "deny",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			ctx.OverwriteHeader("x-denied-by", ep.HeaderValue{RawValue: []byte("deny")})
+			return ctx.CancelRequest(403, map[string]ep.HeaderValue{"x-reason": {RawValue: []byte("policy")}}, "")
+		},
+	}
+	res := extproctest.New(t, ep.Chain(upstream, deny)).Request("GET", "/").Run()
+
+	res.AssertImmediateResponse(403)
+	res.AssertDownstreamHeader("x-internal-upstream")
+	res.AssertDownstreamHeader("x-denied-by", "deny")
+	res.AssertDownstreamHeader("x-reason", "policy")
+	if removed := res.ImmediateResponse().GetHeaders().GetRemoveHeaders(); len(removed) > 0 {
+		t.Errorf("immediate response removes %q", removed)
+	}
+}
+
+func TestChainHeadersView(t *testing.T) {
+	var seen ep.Headers
+	first := &ep.ProcessorFuncs{
+		Name: "first",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			ctx.OverwriteHeader("x-user", ep.HeaderValue{RawValue: []byte("alice")})
+			ctx.AddHeader("x-tenant", ep.HeaderValue{RawValue: []byte("ignored")})
+			return ctx.RemoveHeader("authorization")
+		},
+	}
+	second := &ep.ProcessorFuncs{
+		Name: "second",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			seen = headers
+			if ctx.Headers.Get("x-user") != "mallory" {
+				t.Errorf("ctx.Headers changed: x-user %q", ctx.Headers.Get("x-user"))
+			}
+			return ctx.ContinueRequest()
+		},
+	}
+	h := extproctest.New(t, ep.Chain(first, second))
+	h.Request("GET", "/").
+		Header("x-user", "mallory").
+		Header("x-tenant", "acme").
+		Header("authorization", "Bearer x").
+		Run().AssertContinued()
+
+	if v := seen.Get("x-user"); v != "alice" {
+		t.Errorf("x-user %q, want alice", v)
+	}
+	if v := seen.Values("x-tenant"); !slices.Equal(v, []string{"acme"}) {
+		t.Errorf("x-tenant %q, want [acme]", v)
+	}
+	if seen.Has("authorization") {
+		t.Error("authorization not removed")
+	}
+}
+
+func TestChainBody(t *testing.T) {
+	appending := func(name, suffix string) *ep.ProcessorFuncs {
+		return &ep.ProcessorFuncs{
+			Name: name,
+			RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+				return ctx.ReplaceBodyChunk(append(slices.Clip(body), suffix...))
+			},
+			ResponseBody: func(ctx *ep.RequestContext, body []byte) error {
+				return ctx.ReplaceBodyChunk(append(slices.Clip(body), suffix...))
+			},
+		}
+	}
+	h := extproctest.New(t, ep.Chain(appending("a", "+a"), appending("b", "+b")))
+	res := h.Request("POST", "/").Body("req").Response(200).Body("resp").Run()
+
+	res.AssertContinued()
+	res.AssertUpstreamBody("req+a+b")
+	res.AssertDownstreamBody("resp+b+a")
+}
+
+func TestChainErrorStopsChain(t *testing.T) {
+	called := false
+	failing := &ep.ProcessorFuncs{
+		Name:    "failing",
+		Options: &ep.ProcessingOptions{ErrorPolicy: ep.ContinueOnError()},
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			return errors.New("failed")
+		},
+	}
+	after := &ep.ProcessorFuncs{
+		Name: "after",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			called = true
+			return ctx.ContinueRequest()
+		},
+	}
+	extproctest.New(t, ep.Chain(failing, after)).Request("GET", "/").Run().AssertContinued()
+	if called {
+		t.Error("processor after an error was called")
+	}
+}
@@ -0,0 +1,170 @@
+package extproc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// default bound on decoded (decompressed) bodies
+const kDefaultMaxDecodedBody = 10 << 20
+
+var ErrDecodedBodyTooLarge = errors.New("decoded body exceeds the maximum size")
+
+// BodyCodecOptions enable decoding of compressed bodies according to
+// their content-encoding (gzip, deflate, br and zstd), so processors get
+// plaintext bodies. Only whole bodies can be decoded: with envoy's
+// BUFFERED body mode, or with a BodyBuffer; other chunks are passed as is.
+// When a decoded body is replaced with ReplaceBodyChunk, the replacement
+// is encoded again.
+type BodyCodecOptions struct {
+	// MaxDecodedSize bounds decoded bodies, in bytes (10MiB if not
+	// positive), as protection against decompression bombs. Larger bodies
+	// fail the phase with ErrDecodedBodyTooLarge.
+	MaxDecodedSize int64
+
+	// StripEncoding sends replacements unencoded, removing the
+	// content-encoding header instead. envoy only applies that header
+	// mutation when the whole body is in one chunk (e.g. BUFFERED body
+	// mode), so bodies buffered from several chunks (see BodyBuffer) are
+	// encoded again regardless.
+	StripEncoding bool
+}
+
+// bodyCodec (de)compresses bodies for one content-encoding.
+type bodyCodec struct {
+	decode func(io.Reader) (io.ReadCloser, error)
+	encode func(io.Writer) (io.WriteCloser, error)
+}
+
+var bodyCodecs = map[string]bodyCodec{
+	"gzip": {
+		decode: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		encode: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	},
+	"deflate": {
+		decode: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+		encode: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil },
+	},
+	"br": {
+		decode: func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil },
+		encode: func(w io.Writer) (io.WriteCloser, error) { return brotli.NewWriter(w), nil },
+	},
+	"zstd": {
+		decode: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+		encode: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+	},
+}
+
+// contentEncodings returns the content-encodings in a header map, in the
+// order they were applied.
+func contentEncodings(headers *corev3.HeaderMap) []string {
+	var encodings []string
+	for _, h := range headers.GetHeaders() {
+		if !strings.EqualFold(h.Key, "content-encoding") {
+			continue
+		}
+		value := h.Value
+		if len(h.RawValue) > 0 {
+			value = string(h.RawValue)
+		}
+		for _, e := range strings.Split(value, ",") {
+			if e = strings.ToLower(strings.TrimSpace(e)); e != "" && e != "identity" {
+				encodings = append(encodings, e)
+			}
+		}
+	}
+	return encodings
+}
+
+// ContentEncodings returns the content-encodings of the body (request or
+// response) for the phase.
+func (rc *RequestContext) ContentEncodings(phase int) []string {
+	switch phase {
+	case REQUEST_PHASE_RESPONSE_HEADERS, REQUEST_PHASE_RESPONSE_BODY, REQUEST_PHASE_RESPONSE_TRAILERS:
+		return rc.responseEncodings
+	default:
+		return rc.requestEncodings
+	}
+}
+
+// decodeChunk decodes the phase's body chunk if it is the whole body.
+func (rc *RequestContext) decodeChunk(chunk []byte) ([]byte, error) {
+	if !rc.wholeBodyChunk() {
+		return chunk, nil
+	}
+	return rc.decodeBody(chunk)
+}
+
+// decodeBody decodes a whole body, if body codecs are enabled and all its
+// encodings are supported.
+func (rc *RequestContext) decodeBody(body []byte) ([]byte, error) {
+	encodings := rc.ContentEncodings(rc.phase)
+	if rc.codecOptions == nil || len(encodings) == 0 || len(body) == 0 {
+		return body, nil
+	}
+	for _, e := range encodings {
+		if _, ok := bodyCodecs[e]; !ok {
+			rc.Logger().Debug("not decoding body with unsupported content-encoding", "encoding", e)
+			return body, nil
+		}
+	}
+
+	maxSize := rc.codecOptions.MaxDecodedSize
+	if maxSize <= 0 {
+		maxSize = kDefaultMaxDecodedBody
+	}
+
+	// undo encodings in reverse order of application
+	decoded := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		r, err := bodyCodecs[encodings[i]].decode(bytes.NewReader(decoded))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %s body: %w", encodings[i], err)
+		}
+		decoded, err = io.ReadAll(io.LimitReader(r, maxSize+1))
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %s body: %w", encodings[i], err)
+		}
+		if int64(len(decoded)) > maxSize {
+			return nil, ErrDecodedBodyTooLarge
+		}
+	}
+	rc.decoded = true
+	return decoded, nil
+}
+
+// encodeBody encodes a replacement for a decoded body.
+func (rc *RequestContext) encodeBody(body []byte) ([]byte, error) {
+	encoded := body
+	for _, e := range rc.ContentEncodings(rc.phase) {
+		var buf bytes.Buffer
+		w, err := bodyCodecs[e].encode(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode %s body: %w", e, err)
+		}
+		if _, err := w.Write(encoded); err != nil {
+			return nil, fmt.Errorf("cannot encode %s body: %w", e, err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("cannot encode %s body: %w", e, err)
+		}
+		encoded = buf.Bytes()
+	}
+	return encoded, nil
+}
@@ -0,0 +1,123 @@
+package extproc_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func gunzipped(t *testing.T, b []byte) string {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("body is not gzipped: %v", err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(plain)
+}
+
+// shouting upper cases (decoded) request bodies.
+func shouting(codec *ep.BodyCodecOptions, seen *[]string) *ep.ProcessorFuncs {
+	return &ep.ProcessorFuncs{
+		Name:    "shouting",
+		Options: &ep.ProcessingOptions{BodyCodec: codec},
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			*seen = append(*seen, string(body))
+			return ctx.ReplaceBodyChunk(bytes.ToUpper(body))
+		},
+	}
+}
+
+func TestBodyCodec(t *testing.T) {
+	var seen []string
+	h := extproctest.New(t, shouting(&ep.BodyCodecOptions{}, &seen))
+	res := h.Request("POST", "/").Header("content-encoding", "gzip").Body(gzipped(t, "hello")).Run()
+
+	res.AssertContinued()
+	if len(seen) != 1 || seen[0] != "hello" {
+		t.Errorf("processor got %q, want the decoded body", seen)
+	}
+	res.AssertUpstreamHeader("content-encoding", "gzip")
+	if got := gunzipped(t, res.Upstream().Body); got != "HELLO" {
+		t.Errorf("upstream body %q, want HELLO", got)
+	}
+}
+
+func TestBodyCodecStripEncoding(t *testing.T) {
+	t.Run("single chunk", func(t *testing.T) {
+		var seen []string
+		h := extproctest.New(t, shouting(&ep.BodyCodecOptions{StripEncoding: true}, &seen))
+		res := h.Request("POST", "/").Header("content-encoding", "gzip").Body(gzipped(t, "hello")).Run()
+
+		res.AssertContinued()
+		res.AssertUpstreamHeader("content-encoding")
+		res.AssertUpstreamBody("HELLO")
+	})
+
+	t.Run("buffered chunks", func(t *testing.T) {
+		p := &bufferingProcessor{
+			options: &ep.ProcessingOptions{
+				BodyBuffer: &ep.BodyBufferOptions{},
+				BodyCodec:  &ep.BodyCodecOptions{StripEncoding: true},
+			},
+			upper: true,
+		}
+		body := gzipped(t, "hello")
+		res := extproctest.New(t, p).Request("POST", "/").
+			Header("content-encoding", "gzip").
+			Body(body[:4], body[4:]).
+			Run()
+
+		res.AssertContinued()
+		if len(p.bodies) != 1 || p.bodies[0] != "hello" {
+			t.Errorf("processor got %q, want the decoded body", p.bodies)
+		}
+		res.AssertUpstreamHeader("content-encoding", "gzip")
+		if got := gunzipped(t, res.Upstream().Body); got != "HELLO" {
+			t.Errorf("upstream body %q, want HELLO", got)
+		}
+	})
+}
+
+func TestBodyCodecTooLarge(t *testing.T) {
+	var phaseErr error
+	var seen []string
+	p := shouting(&ep.BodyCodecOptions{MaxDecodedSize: 8}, &seen)
+	p.Options.ErrorPolicy = func(ctx *ep.RequestContext, phase int, err error) error {
+		phaseErr = err
+		return ctx.ContinueRequest()
+	}
+	res := extproctest.New(t, p).Request("POST", "/").
+		Header("content-encoding", "gzip").
+		Body(gzipped(t, "far too long to decode")).
+		Run()
+
+	res.AssertContinued()
+	if !errors.Is(phaseErr, ep.ErrDecodedBodyTooLarge) {
+		t.Errorf("phase error %v, want ErrDecodedBodyTooLarge", phaseErr)
+	}
+	if len(seen) != 0 {
+		t.Errorf("processor called with %q", seen)
+	}
+}
@@ -1,17 +1,20 @@
 package extproc
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"slices"
-	"strconv"
 	"strings"
 	"time"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
 	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
@@ -24,6 +27,27 @@ const (
 	REQUEST_PHASE_RESPONSE_TRAILERS = 6
 )
 
+// PhaseName returns a readable name for a REQUEST_PHASE_* constant, as
+// used in logs.
+func PhaseName(phase int) string {
+	switch phase {
+	case REQUEST_PHASE_REQUEST_HEADERS:
+		return "request_headers"
+	case REQUEST_PHASE_REQUEST_BODY:
+		return "request_body"
+	case REQUEST_PHASE_REQUEST_TRAILERS:
+		return "request_trailers"
+	case REQUEST_PHASE_RESPONSE_HEADERS:
+		return "response_headers"
+	case REQUEST_PHASE_RESPONSE_BODY:
+		return "response_body"
+	case REQUEST_PHASE_RESPONSE_TRAILERS:
+		return "response_trailers"
+	default:
+		return "undetermined"
+	}
+}
+
 const kContentLength = "Content-Length"
 
 type PhaseResponse struct {
@@ -31,6 +55,19 @@ type PhaseResponse struct {
 	bodyMutation      *extprocv3.BodyMutation      // body responses
 	continueRequest   *extprocv3.CommonResponse    // headers/body responses
 	immediateResponse *extprocv3.ImmediateResponse // headers/body responses
+	dynamicMetadata   *structpb.Struct             // any response
+
+	// body chunks emitted in full duplex streaming mode
+	streamedBody []*extprocv3.StreamedBodyResponse
+
+	// replacement of a decoded body, before encoding
+	plainBody []byte
+
+	// trailers added before the trailers phase
+	trailers *corev3.HeaderMap
+
+	clearRouteCache bool // request headers/body responses
+	replaceBody     bool // headers responses (CONTINUE_AND_REPLACE)
 }
 
 type HeaderValue struct {
@@ -47,68 +84,72 @@ type RequestContext struct {
 	FullPath  string
 	RequestID string
 
-	AllHeaders AllHeaders
-
-	Started     time.Time
-	Duration    time.Duration
-	EndOfStream bool
-	data        map[string]any
-	response    PhaseResponse
+	Headers Headers
+
+	Started           time.Time
+	Duration          time.Duration
+	EndOfStream       bool
+	data              map[string]any
+	attributes        map[string]any
+	metadata          *corev3.Metadata
+	phase             int
+	mode              *filterv3.ProcessingMode
+	modeOverridden    bool
+	streamStarted     bool
+	chunk             []byte // received body chunk
+	requestBody       bodyBuffer
+	responseBody      bodyBuffer
+	requestSize       bodySize
+	responseSize      bodySize
+	codecOptions      *BodyCodecOptions
+	requestEncodings  []string
+	responseEncodings []string
+	responseHeaders   Headers
+	decoded           bool // body phase got a decoded body
+	requestTrailers   trailerMutation
+	responseTrailers  trailerMutation
+	logger            *slog.Logger
+	ctx               context.Context
+	streamCtx         context.Context
+	streamSpan        trace.Span
+	phaseSpan         trace.Span
+	response          PhaseResponse
 }
 
 func initReqCtx(rc *RequestContext, headers *corev3.HeaderMap) error {
 	rc.Started = time.Now()
 	rc.Duration = 0
 
-	eitherValue := func(h *corev3.HeaderValue) string {
-		if h == nil {
-			return ""
-		}
-
-		val := h.Value
-		if len(h.RawValue) > 0 {
-			val = string(h.RawValue)
-		}
-		return val
-	}
-
 	// for custom data between phases
 	rc.data = make(map[string]any)
 
 	// for stream phase responses (convenience)
 	rc.ResetPhase()
 
-	// string and byte header processing
+	rc.Headers = newHeaders(headers)
 
-	var err error
-	rc.AllHeaders, err = genHeaders(headers)
-	if err != nil {
-		return fmt.Errorf("parse header is failed: %w", err)
-	}
+	rc.Scheme = rc.Headers.Pseudo(":scheme")
+	rc.Authority = rc.Headers.Pseudo(":authority")
+	rc.Method = rc.Headers.Pseudo(":method")
+	rc.FullPath = rc.Headers.Pseudo(":path")
+	rc.Path = strings.Split(rc.FullPath, "?")[0]
+	rc.RequestID = rc.Headers.Get("x-request-id")
 
-	for _, h := range headers.Headers {
-		switch h.Key {
-		case ":scheme":
-			rc.Scheme = eitherValue(h)
-
-		case ":authority":
-			rc.Authority = eitherValue(h)
-
-		case ":method":
-			rc.Method = eitherValue(h)
-
-		case ":path":
-			rc.FullPath = eitherValue(h)
-			rc.Path = strings.Split(rc.FullPath, "?")[0]
+	return nil
+}
 
-		case "x-request-id":
-			rc.RequestID = eitherValue(h)
+// initLogger sets the per-request logger once request data is known.
+func (rc *RequestContext) initLogger(base *slog.Logger) {
+	rc.logger = base.With("request_id", rc.RequestID, "method", rc.Method, "path", rc.Path)
+}
 
-		default:
-		}
+// Logger returns a structured logger for the request, with the processor
+// name, request ID, method and path as attributes.
+func (rc *RequestContext) Logger() *slog.Logger {
+	if rc.logger == nil {
+		return slog.Default()
 	}
-
-	return nil
+	return rc.logger
 }
 
 func (rc *RequestContext) GetValue(name string) (any, error) {
@@ -120,6 +161,10 @@ func (rc *RequestContext) GetValue(name string) (any, error) {
 }
 
 func (rc *RequestContext) SetValue(name string, val any) error {
+	if rc.data == nil {
+		// request headers phase was skipped
+		rc.data = make(map[string]any)
+	}
 	rc.data[name] = val
 	return nil
 }
@@ -130,6 +175,14 @@ func (rc *RequestContext) ResetPhase() error {
 	rc.response.bodyMutation = nil
 	rc.response.continueRequest = nil
 	rc.response.immediateResponse = nil
+	rc.response.dynamicMetadata = nil
+	rc.response.streamedBody = nil
+	rc.response.plainBody = nil
+	rc.response.trailers = nil
+	rc.response.clearRouteCache = false
+	rc.response.replaceBody = false
+	rc.chunk = nil
+	rc.decoded = false
 	return nil
 }
 
@@ -138,18 +191,24 @@ func (rc *RequestContext) ContinueRequest() error {
 		rc.response.immediateResponse = nil
 	}
 
+	status := extprocv3.CommonResponse_CONTINUE
+	if rc.response.replaceBody {
+		status = extprocv3.CommonResponse_CONTINUE_AND_REPLACE
+	}
+
 	rc.response.continueRequest = &extprocv3.CommonResponse{
-		// status? (ie response phase status)
-		HeaderMutation: rc.response.headerMutation,
-		BodyMutation:   rc.response.bodyMutation,
-		// trailers?
+		Status:          status,
+		HeaderMutation:  rc.response.headerMutation,
+		BodyMutation:    rc.response.bodyMutation,
+		Trailers:        rc.response.trailers,
+		ClearRouteCache: rc.response.clearRouteCache,
 	}
 
 	return nil
 }
 
 func (rc *RequestContext) CancelRequest(status int32, headers map[string]HeaderValue, body string) error {
-	log.Printf("Cancelling request: %d, %v, %s", status, headers, body)
+	rc.Logger().Debug("cancelling request", "status", status)
 	rc.AppendHeaders(headers)
 	rc.response.continueRequest = nil
 	rc.response.immediateResponse = &extprocv3.ImmediateResponse{
@@ -162,7 +221,54 @@ func (rc *RequestContext) CancelRequest(status int32, headers map[string]HeaderV
 	return nil
 }
 
+// GetResponse returns the response to send envoy for the phase or, when
+// body chunks are streamed (see EmitBodyChunk), the first one of them (nil
+// if there is none).
 func (rc *RequestContext) GetResponse(phase int) (*extprocv3.ProcessingResponse, error) {
+	resps, err := rc.GetResponses(phase)
+	if err != nil || len(resps) == 0 {
+		return nil, err
+	}
+	return resps[0], nil
+}
+
+// GetResponses returns all responses to send envoy for the phase: one
+// per streamed body chunk in full duplex streaming mode (possibly none),
+// otherwise exactly one.
+func (rc *RequestContext) GetResponses(phase int) ([]*extprocv3.ProcessingResponse, error) {
+	if rc.response.immediateResponse == nil {
+		switch phase {
+		case REQUEST_PHASE_REQUEST_BODY, REQUEST_PHASE_RESPONSE_BODY:
+			if chunks, streamed := rc.streamedChunks(phase); streamed {
+				return rc.streamedResponses(phase, chunks), nil
+			}
+		}
+	}
+
+	resp, err := rc.phaseResponse(phase)
+	if err != nil {
+		return nil, err
+	}
+
+	// response-level (rather than phase-level) fields
+	resp.DynamicMetadata = rc.response.dynamicMetadata
+	resp.ModeOverride = rc.modeOverride()
+
+	// a buffered body completed in the trailers phase goes first
+	if chunks := rc.response.streamedBody; len(chunks) > 0 && rc.isTrailersPhase() {
+		bodyPhase := REQUEST_PHASE_REQUEST_BODY
+		if phase == REQUEST_PHASE_RESPONSE_TRAILERS {
+			bodyPhase = REQUEST_PHASE_RESPONSE_BODY
+		}
+		resps := rc.streamedResponses(bodyPhase, chunks)
+		resps[0].DynamicMetadata = nil
+		return append(resps, resp), nil
+	}
+
+	return []*extprocv3.ProcessingResponse{resp}, nil
+}
+
+func (rc *RequestContext) phaseResponse(phase int) (*extprocv3.ProcessingResponse, error) {
 	// handle immediate responses
 	if rc.response.immediateResponse != nil {
 		switch phase {
@@ -340,20 +446,40 @@ func (rc *RequestContext) RemoveHeadersVariadic(headers ...string) error {
 }
 
 func (rc *RequestContext) ReplaceBodyChunk(body []byte) error {
-	size := len(body)
-	if size == 0 {
-		return nil
+	if len(body) == 0 {
+		return rc.ClearBodyChunk()
 	}
 
+	// decoded bodies are replaced in the original encoding, unless it can
+	// be stripped: envoy ignores header mutations in body phases after
+	// the first chunk, so only for bodies received in a single chunk
+	if rc.decoded {
+		rc.response.plainBody = body
+		if rc.codecOptions.StripEncoding && rc.wholeBodyChunk() {
+			rc.RemoveHeader("content-encoding")
+		} else {
+			encoded, err := rc.encodeBody(body)
+			if err != nil {
+				return err
+			}
+			body = encoded
+		}
+	}
+
+	rc.replaceBody(body)
+	return nil
+}
+
+// replaceBody replaces the body chunk as is.
+func (rc *RequestContext) replaceBody(body []byte) {
 	rc.response.bodyMutation = &extprocv3.BodyMutation{
 		Mutation: &extprocv3.BodyMutation_Body{
 			Body: body,
 		},
 	}
 
-	rc.OverwriteHeader(kContentLength, HeaderValue{RawValue: []byte(strconv.Itoa(size))})
-
-	return nil
+	// only set if the chunk is the whole body, see WillMutateBody
+	rc.setBodyLength(len(body))
 }
 
 func (rc *RequestContext) ClearBodyChunk() error {
@@ -362,5 +488,7 @@ func (rc *RequestContext) ClearBodyChunk() error {
 			ClearBody: true,
 		},
 	}
+	rc.response.plainBody = nil
+	rc.setBodyLength(0)
 	return nil
 }
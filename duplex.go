@@ -0,0 +1,101 @@
+package extproc
+
+import (
+	"errors"
+
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+var errEmitBodyPhase = errors.New("body chunks can only be emitted in body phases")
+
+// EmitBodyChunk sends a body chunk to envoy as a streamed body mutation,
+// for the FULL_DUPLEX_STREAMED body mode. In that mode envoy forwards
+// only the chunks the processor sends back, so a phase can emit any number
+// of chunks, and endOfStream should be set on the last chunk of the body.
+//
+// A full duplex body phase that emits no chunks passes on the received
+// chunk, as replaced by ReplaceBodyChunk if called. To hold data back
+// (e.g. while accumulating data to transform), call ClearBodyChunk so
+// nothing is sent. Full duplex mode is only detected from the
+// ProcessingMode in the ProcessingOptions, which should match the envoy
+// filter config.
+func (rc *RequestContext) EmitBodyChunk(data []byte, endOfStream bool) error {
+	switch rc.phase {
+	case REQUEST_PHASE_REQUEST_BODY, REQUEST_PHASE_RESPONSE_BODY:
+	default:
+		return errEmitBodyPhase
+	}
+	rc.response.streamedBody = append(rc.response.streamedBody, &extprocv3.StreamedBodyResponse{
+		Body:        data,
+		EndOfStream: endOfStream,
+	})
+	return nil
+}
+
+// FullDuplexBody reports whether the body for the phase (request or
+// response) is processed in FULL_DUPLEX_STREAMED mode.
+func (rc *RequestContext) FullDuplexBody(phase int) bool {
+	if rc.mode == nil {
+		return false
+	}
+	switch phase {
+	case REQUEST_PHASE_REQUEST_HEADERS, REQUEST_PHASE_REQUEST_BODY, REQUEST_PHASE_REQUEST_TRAILERS:
+		return rc.mode.RequestBodyMode == filterv3.ProcessingMode_FULL_DUPLEX_STREAMED
+	case REQUEST_PHASE_RESPONSE_HEADERS, REQUEST_PHASE_RESPONSE_BODY, REQUEST_PHASE_RESPONSE_TRAILERS:
+		return rc.mode.ResponseBodyMode == filterv3.ProcessingMode_FULL_DUPLEX_STREAMED
+	}
+	return false
+}
+
+// streamedChunks returns the chunks to stream back to envoy for a body
+// phase, and whether the phase is answered with streamed chunks at all.
+func (rc *RequestContext) streamedChunks(phase int) ([]*extprocv3.StreamedBodyResponse, bool) {
+	if len(rc.response.streamedBody) > 0 {
+		return rc.response.streamedBody, true
+	}
+	if !rc.FullDuplexBody(phase) {
+		return nil, false
+	}
+
+	// pass on the (possibly replaced) received chunk
+	chunk := &extprocv3.StreamedBodyResponse{Body: rc.chunk, EndOfStream: rc.EndOfStream}
+	if rc.response.bodyMutation != nil {
+		switch m := rc.response.bodyMutation.Mutation.(type) {
+		case *extprocv3.BodyMutation_Body:
+			chunk.Body = m.Body
+		case *extprocv3.BodyMutation_ClearBody:
+			if m.ClearBody {
+				return nil, true
+			}
+		}
+	}
+	return []*extprocv3.StreamedBodyResponse{chunk}, true
+}
+
+// streamedResponses wraps each streamed chunk in a body phase response.
+func (rc *RequestContext) streamedResponses(phase int, chunks []*extprocv3.StreamedBodyResponse) []*extprocv3.ProcessingResponse {
+	resps := make([]*extprocv3.ProcessingResponse, 0, len(chunks))
+	for _, chunk := range chunks {
+		body := &extprocv3.BodyResponse{
+			Response: &extprocv3.CommonResponse{
+				BodyMutation: &extprocv3.BodyMutation{
+					Mutation: &extprocv3.BodyMutation_StreamedResponse{
+						StreamedResponse: chunk,
+					},
+				},
+			},
+		}
+		resp := &extprocv3.ProcessingResponse{}
+		if phase == REQUEST_PHASE_REQUEST_BODY {
+			resp.Response = &extprocv3.ProcessingResponse_RequestBody{RequestBody: body}
+		} else {
+			resp.Response = &extprocv3.ProcessingResponse_ResponseBody{ResponseBody: body}
+		}
+		resps = append(resps, resp)
+	}
+	if len(resps) > 0 {
+		resps[0].DynamicMetadata = rc.response.dynamicMetadata
+	}
+	return resps
+}
@@ -0,0 +1,132 @@
+package extproc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+// HTTPError can be returned from any phase handler to respond to the
+// client immediately with the given status, headers and body, regardless
+// of the configured ErrorPolicy. In trailers phases, where envoy does not
+// accept immediate responses, the request continues unchanged.
+type HTTPError struct {
+	Status  int32
+	Headers map[string]HeaderValue
+	Body    string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("http error %d", e.Status)
+	}
+	return fmt.Sprintf("http error %d: %s", e.Status, e.Body)
+}
+
+// NewHTTPError is a convenience constructor for an HTTPError without
+// extra headers.
+func NewHTTPError(status int32, body string) *HTTPError {
+	return &HTTPError{Status: status, Body: body}
+}
+
+// ErrorPolicy decides what is sent to envoy when a phase handler returns
+// an error. A policy can define the phase response on the RequestContext
+// (e.g. with CancelRequest or ContinueRequest) and return nil to have it
+// sent, or return an error to terminate the gRPC stream. Errors carrying
+// a gRPC status (see google.golang.org/grpc/status) keep their code, any
+// other error terminates the stream with codes.Unknown.
+//
+// When ProcessingOptions.ErrorPolicy is nil the error is logged and the
+// client gets an empty 500 immediate response (trailers phases continue
+// unchanged), as with RespondOnError(500, nil, "").
+type ErrorPolicy func(ctx *RequestContext, phase int, err error) error
+
+// RespondOnError responds to the client immediately with a fixed status,
+// headers and body whenever a phase handler fails (in trailers phases the
+// request continues unchanged, as for HTTPError).
+func RespondOnError(status int32, headers map[string]HeaderValue, body string) ErrorPolicy {
+	return func(ctx *RequestContext, phase int, err error) error {
+		return ctx.respondOnError(status, headers, body)
+	}
+}
+
+// RespondWithJSONError responds to the client immediately with the given
+// status and a JSON body of the form {"error": "<message>"}. Note that
+// the error message is passed on to the client. Trailers phases continue
+// unchanged, as for HTTPError.
+func RespondWithJSONError(status int32) ErrorPolicy {
+	return func(ctx *RequestContext, phase int, err error) error {
+		body, jerr := json.Marshal(map[string]string{"error": err.Error()})
+		if jerr != nil {
+			return jerr
+		}
+		headers := map[string]HeaderValue{"content-type": {RawValue: []byte("application/json")}}
+		return ctx.respondOnError(status, headers, string(body))
+	}
+}
+
+// ContinueOnError "fails open", continuing the request without any of
+// the mutations made in the failing phase.
+func ContinueOnError() ErrorPolicy {
+	return func(ctx *RequestContext, phase int, err error) error {
+		ctx.discardPhaseResponse()
+		return ctx.ContinueRequest()
+	}
+}
+
+// TerminateOnError ends the gRPC stream with the given status code,
+// leaving envoy to apply the filter's failure_mode_allow setting.
+func TerminateOnError(code codes.Code) ErrorPolicy {
+	return func(ctx *RequestContext, phase int, err error) error {
+		return status.Errorf(code, "%s processing error: %v", PhaseName(phase), err)
+	}
+}
+
+func (s *GenericExtProcServer) handleError(rc *RequestContext, phase int, err error) error {
+	var herr *HTTPError
+	if errors.As(err, &herr) {
+		status := herr.Status
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		return rc.respondOnError(status, herr.Headers, herr.Body)
+	}
+
+	if s.options.ErrorPolicy == nil {
+		rc.Logger().Error("phase processing error", "phase", PhaseName(phase), "error", err)
+		return rc.respondOnError(http.StatusInternalServerError, nil, "")
+	}
+	return s.options.ErrorPolicy(rc, phase, err)
+}
+
+// discardPhaseResponse drops any response and mutations defined in the
+// current phase, keeping the phase's stream state, and in trailers phases
+// any buffered body still to send (see completeBufferedBody).
+func (rc *RequestContext) discardPhaseResponse() {
+	eos := rc.EndOfStream
+	var body []*extprocv3.StreamedBodyResponse
+	if rc.isTrailersPhase() {
+		body = rc.response.streamedBody
+	}
+	_ = rc.ResetPhase()
+	rc.EndOfStream = eos
+	rc.response.streamedBody = body
+}
+
+// respondOnError replaces the phase's response with an immediate response,
+// except in trailers phases, where envoy does not accept one and the
+// error's headers would otherwise be sent as trailer mutations: there the
+// request continues unchanged.
+func (rc *RequestContext) respondOnError(status int32, headers map[string]HeaderValue, body string) error {
+	rc.discardPhaseResponse()
+	if rc.isTrailersPhase() {
+		return rc.ContinueRequest()
+	}
+	return rc.CancelRequest(status, headers, body)
+}
@@ -0,0 +1,144 @@
+package extproc_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// failing sets a header in request headers, then fails with err.
+func failing(policy ep.ErrorPolicy, err error) *ep.ProcessorFuncs {
+	return &ep.ProcessorFuncs{
+		Name:    "failing",
+		Options: &ep.ProcessingOptions{ErrorPolicy: policy},
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			ctx.OverwriteHeader("x-partial", ep.HeaderValue{RawValue: []byte("1")})
+			return err
+		},
+	}
+}
+
+func TestErrorPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ep.ErrorPolicy
+		err    error
+		check  func(t *testing.T, res *extproctest.Result)
+	}{
+		{
+			name:   "respond",
+			policy: ep.RespondOnError(502, map[string]ep.HeaderValue{"x-error": {RawValue: []byte("1")}}, "bad gateway"),
+			err:    errors.New("boom"),
+			check: func(t *testing.T, res *extproctest.Result) {
+				res.AssertImmediateResponse(502)
+				res.AssertDownstreamHeader("x-error", "1")
+				res.AssertDownstreamHeader("x-partial")
+				res.AssertDownstreamBody("bad gateway")
+			},
+		},
+		{
+			name:   "json",
+			policy: ep.RespondWithJSONError(500),
+			err:    errors.New("boom"),
+			check: func(t *testing.T, res *extproctest.Result) {
+				res.AssertImmediateResponse(500)
+				res.AssertDownstreamHeader("content-type", "application/json")
+				res.AssertDownstreamBody(`{"error":"boom"}`)
+			},
+		},
+		{
+			name:   "continue",
+			policy: ep.ContinueOnError(),
+			err:    errors.New("boom"),
+			check: func(t *testing.T, res *extproctest.Result) {
+				res.AssertContinued()
+				res.AssertNoHeader(ep.REQUEST_PHASE_REQUEST_HEADERS, "x-partial")
+				res.AssertUpstreamHeader("x-partial")
+			},
+		},
+		{
+			name:   "default",
+			policy: nil,
+			err:    errors.New("boom"),
+			check: func(t *testing.T, res *extproctest.Result) {
+				res.AssertImmediateResponse(500)
+				res.AssertDownstreamHeader("x-partial")
+				res.AssertDownstreamBody("")
+			},
+		},
+		{
+			name:   "http error overrides policy",
+			policy: ep.ContinueOnError(),
+			err:    &ep.HTTPError{Status: 429, Headers: map[string]ep.HeaderValue{"retry-after": {RawValue: []byte("1")}}},
+			check: func(t *testing.T, res *extproctest.Result) {
+				res.AssertImmediateResponse(429)
+				res.AssertDownstreamHeader("retry-after", "1")
+			},
+		},
+		{
+			name:   "http error without status",
+			policy: nil,
+			err:    &ep.HTTPError{Body: "oops"},
+			check: func(t *testing.T, res *extproctest.Result) {
+				res.AssertImmediateResponse(500)
+				res.AssertDownstreamBody("oops")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := extproctest.New(t, failing(tt.policy, tt.err)).Request("GET", "/").Response(200).Run()
+			res.AssertNoError()
+			tt.check(t, res)
+		})
+	}
+}
+
+func TestErrorInTrailersContinues(t *testing.T) {
+	policies := map[string]ep.ErrorPolicy{
+		"http error": nil,
+		"respond":    ep.RespondOnError(500, map[string]ep.HeaderValue{"x-error": {RawValue: []byte("1")}}, ""),
+	}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			h := extproctest.New(t, &ep.ProcessorFuncs{
+				Name:    "trailers",
+				Options: &ep.ProcessingOptions{ErrorPolicy: policy},
+				RequestTrailers: func(ctx *ep.RequestContext, trailers ep.Headers) error {
+					ctx.OverwriteHeader("x-checksum", ep.HeaderValue{RawValue: []byte("bad")})
+					return ep.NewHTTPError(400, "bad checksum")
+				},
+			})
+			res := h.Request("POST", "/").Body("data").Trailer("x-checksum", "abc").Response(200).Run()
+
+			res.AssertContinued()
+			if hm := res.Phase(ep.REQUEST_PHASE_REQUEST_TRAILERS).HeaderMutation(); len(hm.GetSetHeaders()) > 0 {
+				t.Errorf("trailer mutations sent: %v", hm)
+			}
+			if got := res.Upstream().Trailers.Get("x-checksum"); got != "abc" {
+				t.Errorf("upstream trailer x-checksum %q, want abc", got)
+			}
+			if res.Phase(ep.REQUEST_PHASE_RESPONSE_HEADERS) == nil {
+				t.Error("exchange did not continue after the trailers phase")
+			}
+		})
+	}
+}
+
+func TestTerminateOnError(t *testing.T) {
+	res := extproctest.New(t, failing(ep.TerminateOnError(codes.Unavailable), errors.New("boom"))).
+		Request("GET", "/").Response(200).Run()
+
+	if status.Code(res.Err) != codes.Unavailable {
+		t.Fatalf("stream error %v, want code Unavailable", res.Err)
+	}
+	if msg := status.Convert(res.Err).Message(); !strings.Contains(msg, "request_headers") || !strings.Contains(msg, "boom") {
+		t.Errorf("stream error message %q, want the phase name and error", msg)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/google/uuid"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
@@ -61,7 +63,7 @@ func (r *HttpResponse) getEnvoyPhases() []extprocv3.ProcessingRequest {
 func newRequestHeadersPhase(method string, path string, headers map[string]string) extprocv3.ProcessingRequest {
 	hm := &corev3.HeaderMap{}
 	hm.Headers = append(hm.Headers, &corev3.HeaderValue{Key: ":scheme", Value: "http"})
-	hm.Headers = append(hm.Headers, &corev3.HeaderValue{Key: ":authority", Value: *serverAddr})
+	hm.Headers = append(hm.Headers, &corev3.HeaderValue{Key: ":authority", Value: authority()})
 	hm.Headers = append(hm.Headers, &corev3.HeaderValue{Key: ":method", Value: method})
 	hm.Headers = append(hm.Headers, &corev3.HeaderValue{Key: ":path", Value: path})
 	hm.Headers = append(hm.Headers, &corev3.HeaderValue{Key: "x-request-id", Value: uuid.New().String()})
@@ -82,6 +84,14 @@ func newRequestHeadersPhase(method string, path string, headers map[string]strin
 	}
 }
 
+// authority for mock requests; unix socket addresses have no host
+func authority() string {
+	if strings.HasPrefix(*serverAddr, "unix:") {
+		return "localhost"
+	}
+	return *serverAddr
+}
+
 func newRequestBodyPhase(body string) extprocv3.ProcessingRequest {
 	return extprocv3.ProcessingRequest{
 		Request: &extprocv3.ProcessingRequest_RequestBody{
@@ -13,7 +13,7 @@ import (
 	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 )
 
-var serverAddr = flag.String("addr", "0.0.0.0:50051", "The server address in the format of host:port (default: 0.0.0.0:50051)")
+var serverAddr = flag.String("addr", "0.0.0.0:50051", "The server address in the format of host:port or unix:///path/to/socket (default: 0.0.0.0:50051)")
 
 func (req *envoyStream) processRequest(client extprocv3.ExternalProcessorClient) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -7,6 +7,7 @@ import (
 )
 
 type dataRequestProcessor struct {
+	ep.BaseProcessor
 	opts *ep.ProcessingOptions
 }
 
@@ -18,33 +19,24 @@ func (s *dataRequestProcessor) GetOptions() *ep.ProcessingOptions {
 	return s.opts
 }
 
-func (s *dataRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
-	ctx.SetValue("customId", uuid.New())
-	return ctx.ContinueRequest() // returns an error if response malformed
+func (s *dataRequestProcessor) ProcessedPhases() []int {
+	return []int{
+		ep.REQUEST_PHASE_REQUEST_HEADERS,
+		ep.REQUEST_PHASE_RESPONSE_HEADERS,
+	}
 }
 
-func (s *dataRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body []byte) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *dataRequestProcessor) ProcessRequestTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
+func (s *dataRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
+	ctx.SetValue("customId", uuid.New())
+	return ctx.ContinueRequest() // returns an error if response malformed
 }
 
-func (s *dataRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+func (s *dataRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
 	id, _ := ctx.GetValue("customId")
 	ctx.AddHeader("x-extproc-custom-data", ep.HeaderValue{RawValue: []byte((id.(uuid.UUID)).String())})
 	return ctx.ContinueRequest() // returns an error if response malformed
 }
 
-func (s *dataRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, body []byte) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *dataRequestProcessor) ProcessResponseTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
 func (s *dataRequestProcessor) Init(opts *ep.ProcessingOptions, nonFlagArgs []string) error {
 	s.opts = opts
 	return nil
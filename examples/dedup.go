@@ -10,6 +10,7 @@ import (
 var cache map[string]bool
 
 type dedupRequestProcessor struct {
+	ep.BaseProcessor
 	opts *ep.ProcessingOptions
 }
 
@@ -22,11 +23,12 @@ func dedupable(ctx *ep.RequestContext) bool {
 	}
 }
 
-func cacheRequest(_ *ep.RequestContext, digest string) {
+func cacheRequest(ctx *ep.RequestContext, digest string) {
 	if cache == nil {
 		cache = make(map[string]bool)
 	}
 	cache[digest] = true
+	ctx.SetValue("cached", true)
 }
 
 func uncacheRequest(digest string) {
@@ -35,6 +37,16 @@ func uncacheRequest(digest string) {
 	}
 }
 
+// releaseRequest uncaches the digest cached by this request, once, so a
+// later release can't drop the entry of a newer identical request
+func releaseRequest(ctx *ep.RequestContext) {
+	if cached, _ := ctx.GetValue("cached"); cached == true {
+		digest, _ := getDigest(ctx)
+		uncacheRequest(digest)
+		ctx.SetValue("cached", false)
+	}
+}
+
 func isRequestCached(digest string) bool {
 	if cache == nil {
 		cache = make(map[string]bool)
@@ -52,7 +64,7 @@ func (s *dedupRequestProcessor) GetOptions() *ep.ProcessingOptions {
 	return s.opts
 }
 
-func (s *dedupRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+func (s *dedupRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
 	hasher := sha256.New()
 	ctx.SetValue("hasher", hasher)
 
@@ -96,13 +108,9 @@ func (s *dedupRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body
 	return ctx.ContinueRequest()
 }
 
-func (s *dedupRequestProcessor) ProcessRequestTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *dedupRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+func (s *dedupRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
+	releaseRequest(ctx)
 	digest, _ := getDigest(ctx)
-	uncacheRequest(digest)
 	if ctx.EndOfStream {
 		ctx.AddHeader("x-extproc-request-digest", ep.HeaderValue{RawValue: []byte(digest)})
 	}
@@ -110,16 +118,18 @@ func (s *dedupRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, h
 }
 
 func (s *dedupRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, body []byte) error {
+	releaseRequest(ctx)
 	digest, _ := getDigest(ctx)
-	uncacheRequest(digest)
 	if ctx.EndOfStream {
 		ctx.AddHeader("x-extproc-request-digest", ep.HeaderValue{RawValue: []byte(digest)})
 	}
 	return ctx.ContinueRequest()
 }
 
-func (s *dedupRequestProcessor) ProcessResponseTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
+// OnStreamEnd releases the cache entry for requests that end without a
+// response (e.g. upstream timeouts or client disconnects)
+func (s *dedupRequestProcessor) OnStreamEnd(ctx *ep.RequestContext, err error) {
+	releaseRequest(ctx)
 }
 
 func (s *dedupRequestProcessor) Init(opts *ep.ProcessingOptions, extnonFlagArgsraArgs []string) error {
@@ -9,6 +9,7 @@ import (
 )
 
 type digestRequestProcessor struct {
+	ep.BaseProcessor
 	opts *ep.ProcessingOptions
 }
 
@@ -36,7 +37,7 @@ func (s *digestRequestProcessor) GetOptions() *ep.ProcessingOptions {
 	return s.opts
 }
 
-func (s *digestRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+func (s *digestRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
 	hasher := sha256.New()
 	ctx.SetValue("hasher", hasher)
 
@@ -64,11 +65,7 @@ func (s *digestRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body
 	return ctx.ContinueRequest()
 }
 
-func (s *digestRequestProcessor) ProcessRequestTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *digestRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+func (s *digestRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
 	if ctx.EndOfStream {
 		digest, _ := getDigest(ctx)
 		ctx.AddHeader("x-extproc-request-digest", ep.HeaderValue{RawValue: []byte(digest)})
@@ -84,10 +81,6 @@ func (s *digestRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, bod
 	return ctx.ContinueRequest()
 }
 
-func (s *digestRequestProcessor) ProcessResponseTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
 func (s *digestRequestProcessor) Init(opts *ep.ProcessingOptions, nonFlagArgs []string) error {
 	s.opts = opts
 	return nil
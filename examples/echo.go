@@ -8,14 +8,19 @@ import (
 	extproc "github.com/wrossmorrow/envoy-extproc-sdk-go"
 )
 
+var echoPathRx = regexp.MustCompile("/echo/.*")
+
 type echoRequestProcessor struct {
+	ep.BaseProcessor
 	opts *ep.ProcessingOptions
 }
 
-func joinHeaders(mvhs map[string][]string) map[string]extproc.HeaderValue {
+func joinHeaders(headers extproc.Headers) map[string]extproc.HeaderValue {
 	hs := make(map[string]extproc.HeaderValue)
-	for n, vs := range mvhs {
-		hs[n] = extproc.HeaderValue{Value: strings.Join(vs, ",")}
+	for _, f := range headers.Fields() {
+		if _, seen := hs[f.Name]; !seen {
+			hs[f.Name] = extproc.HeaderValue{Value: strings.Join(headers.Values(f.Name), ",")}
+		}
 	}
 	return hs
 }
@@ -28,20 +33,23 @@ func (s *echoRequestProcessor) GetOptions() *ep.ProcessingOptions {
 	return s.opts
 }
 
-func (s *echoRequestProcessor) PreprocessContext(ctx *ep.RequestContext) error {
-	echoPathRx, _ := regexp.Compile("/echo/.*")
-	ctx.SetValue("echoPath", echoPathRx)
+func (s *echoRequestProcessor) OnStreamStart(ctx *ep.RequestContext) error {
+	ctx.SetValue("echoPath", echoPathRx.MatchString(ctx.Path))
 	return nil
 }
 
-func (s *echoRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
-	match, _ := regexp.MatchString("/echo/.*", ctx.Path)
-	if !match {
+func isEchoPath(ctx *ep.RequestContext) bool {
+	match, _ := ctx.GetValue("echoPath")
+	return match == true
+}
+
+func (s *echoRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
+	if !isEchoPath(ctx) {
 		return ctx.ContinueRequest()
 	}
 
 	if ctx.EndOfStream {
-		return ctx.CancelRequest(200, joinHeaders(ctx.AllHeaders.Headers), "")
+		return ctx.CancelRequest(200, joinHeaders(ctx.Headers), "")
 	}
 	return ctx.ContinueRequest()
 
@@ -56,27 +64,10 @@ func (s *echoRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, hea
 }
 
 func (s *echoRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body []byte) error {
-	match, _ := regexp.MatchString("/echo/.*", ctx.Path)
-	if !match {
+	if !isEchoPath(ctx) {
 		return ctx.ContinueRequest()
 	}
-	return ctx.CancelRequest(200, joinHeaders(ctx.AllHeaders.Headers), string(body))
-}
-
-func (s *echoRequestProcessor) ProcessRequestTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *echoRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *echoRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, body []byte) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *echoRequestProcessor) ProcessResponseTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
+	return ctx.CancelRequest(200, joinHeaders(ctx.Headers), string(body))
 }
 
 func (s *echoRequestProcessor) Init(opts *ep.ProcessingOptions, nonFlagArgs []string) error {
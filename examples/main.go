@@ -3,7 +3,9 @@ package main
 import (
 	"flag"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
 
 	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
 )
@@ -26,9 +28,10 @@ var processors = map[string]processor{
 	"echo":    &echoRequestProcessor{},
 }
 
-func parseArgs(args []string) (port *int, opts *ep.ProcessingOptions, nonFlagArgs []string) {
+func parseArgs(args []string) (port *int, opts *ep.ProcessingOptions, serveOpts []ep.Option, nonFlagArgs []string) {
 	rootCmd := flag.NewFlagSet("root", flag.ExitOnError)
 	port = rootCmd.Int("port", 50051, "the gRPC port.")
+	addr := rootCmd.String("addr", "", "the address to listen on, e.g. unix:///tmp/extproc.sock (overrides port if set).")
 
 	opts = ep.NewDefaultOptions()
 
@@ -37,7 +40,36 @@ func parseArgs(args []string) (port *int, opts *ep.ProcessingOptions, nonFlagArg
 	rootCmd.BoolVar(&opts.UpdateExtProcHeader, "update-extproc-header", false, "update the extProc header or not.")
 	rootCmd.BoolVar(&opts.UpdateDurationHeader, "update-duration-header", false, "update the duration header or not.")
 
+	logJSON := rootCmd.Bool("log-json", false, "log as JSON or not.")
+	tlsCert := rootCmd.String("tls-cert", "", "the TLS certificate file (serves plaintext if empty).")
+	tlsKey := rootCmd.String("tls-key", "", "the TLS key file.")
+	tlsClientCA := rootCmd.String("tls-client-ca", "", "the CA bundle to verify client certificates with (mTLS).")
+	metricsAddr := rootCmd.String("metrics-addr", "", "the address to serve prometheus metrics on (disabled if empty).")
+	bodyBufferSize := rootCmd.Int("body-buffer-size", 0, "buffer streamed bodies up to this many bytes (disabled if 0).")
+	decodeBodies := rootCmd.Bool("decode-bodies", false, "decode compressed (gzip, br, zstd) bodies or not.")
+
 	rootCmd.Parse(args)
+	if *logJSON {
+		opts.Logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
+	if *bodyBufferSize > 0 {
+		opts.BodyBuffer = &ep.BodyBufferOptions{MaxSize: *bodyBufferSize}
+	}
+	if *decodeBodies {
+		opts.BodyCodec = &ep.BodyCodecOptions{}
+	}
+	if *addr != "" {
+		serveOpts = append(serveOpts, ep.WithAddress(*addr))
+	}
+	if *tlsCert != "" {
+		serveOpts = append(serveOpts, ep.WithTLS(*tlsCert, *tlsKey))
+	}
+	if *tlsClientCA != "" {
+		serveOpts = append(serveOpts, ep.WithClientCA(*tlsClientCA))
+	}
+	if *metricsAddr != "" {
+		serveOpts = append(serveOpts, ep.WithMetricsListener(*metricsAddr))
+	}
 	nonFlagArgs = rootCmd.Args()
 	return
 }
@@ -49,17 +81,24 @@ func main() {
 		log.Fatal("Passing a processor is required.")
 	}
 
-	cmd := args[1]
-	proc, exists := processors[cmd]
-	if !exists {
-		log.Fatalf("Processor \"%s\" not defined.", cmd)
+	// processors can be chained with commas, e.g. "digest,timer"
+	var chain []ep.RequestProcessor
+	port, opts, serveOpts, nonFlagArgs := parseArgs(os.Args[2:])
+	for _, cmd := range strings.Split(args[1], ",") {
+		proc, exists := processors[cmd]
+		if !exists {
+			log.Fatalf("Processor \"%s\" not defined.", cmd)
+		}
+		if err := proc.Init(opts, nonFlagArgs); err != nil {
+			log.Fatalf("Initialize the processor is failed: %v.", err.Error())
+		}
+		defer proc.Finish()
+		chain = append(chain, proc)
 	}
 
-	port, opts, nonFlagArgs := parseArgs(os.Args[2:])
-	if err := proc.Init(opts, nonFlagArgs); err != nil {
-		log.Fatalf("Initialize the processor is failed: %v.", err.Error())
+	if len(chain) == 1 {
+		ep.Serve(*port, chain[0], serveOpts...)
+	} else {
+		ep.Serve(*port, ep.Chain(chain...), serveOpts...)
 	}
-	defer proc.Finish()
-
-	ep.Serve(*port, proc)
 }
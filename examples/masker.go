@@ -2,7 +2,7 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"strings"
 
 	"github.com/nqd/flat"
 
@@ -18,17 +18,14 @@ var masked = map[string][]string{
 }
 
 type maskerRequestProcessor struct {
+	ep.BaseProcessor
 	opts *ep.ProcessingOptions
 }
 
-func isMaybeJSON(headers map[string][]string) bool {
-	types, exists := headers["content-type"]
-	if !exists {
-		return false
-	}
-
-	for _, t := range types {
-		if t == "application/json" {
+func isMaybeJSON(headers ep.Headers) bool {
+	for _, t := range headers.Values("content-type") {
+		mediaType, _, _ := strings.Cut(t, ";")
+		if strings.TrimSpace(mediaType) == "application/json" {
 			return true
 		}
 	}
@@ -81,22 +78,34 @@ func (s *maskerRequestProcessor) GetOptions() *ep.ProcessingOptions {
 	return s.opts
 }
 
-func (s *maskerRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+// with envoy's BUFFERED body mode each body phase has the whole body,
+// otherwise set a body buffer in the options (-body-buffer-size)
+
+func (s *maskerRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
+	// streamed bodies are masked in their last chunk, after the headers
+	// have gone upstream with the original content-length
+	if s.opts.BodyBuffer != nil && len(masked["request"]) > 0 && isMaybeJSON(headers) {
+		ctx.WillMutateBody()
+	}
 	return ctx.ContinueRequest()
 }
 
 func (s *maskerRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body []byte) error {
+	return s.ProcessRequestBodyComplete(ctx, body)
+}
+
+func (s *maskerRequestProcessor) ProcessRequestBodyComplete(ctx *ep.RequestContext, body []byte) error {
 	// unmarshal JSON body (if content-type: application/json)
 	// examine for matching paths
 	// "mask" data at all matching paths
 	// replace body, unmarshalled to []byte
 	if len(masked["request"]) > 0 {
-		log.Print("examining request body")
-		if isMaybeJSON(ctx.AllHeaders.Headers) {
-			log.Print("request body may be JSON")
+		ctx.Logger().Debug("examining request body")
+		if isMaybeJSON(ctx.Headers) {
+			ctx.Logger().Debug("request body may be JSON")
 			masked, err := maskJSONData(masked["request"], body)
 			if err != nil {
-				log.Printf("Error: %v", err)
+				ctx.Logger().Warn("cannot mask JSON body", "error", err)
 			} else {
 				ctx.ReplaceBodyChunk(masked)
 			}
@@ -105,26 +114,29 @@ func (s *maskerRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body
 	return ctx.ContinueRequest()
 }
 
-func (s *maskerRequestProcessor) ProcessRequestTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *maskerRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+func (s *maskerRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
 	ctx.SetValue("responseHeaders", headers)
+	if s.opts.BodyBuffer != nil && len(masked["response"]) > 0 && isMaybeJSON(headers) {
+		ctx.WillMutateBody()
+	}
 	return ctx.ContinueRequest()
 }
 
 func (s *maskerRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, body []byte) error {
+	return s.ProcessResponseBodyComplete(ctx, body)
+}
+
+func (s *maskerRequestProcessor) ProcessResponseBodyComplete(ctx *ep.RequestContext, body []byte) error {
 	// unmarshal JSON body (if content-type: application/json)
 	// examine for matching paths
 	// "mask" data at all matching paths
 	// replace body, unmarshalled to []byte
 	if len(masked["response"]) > 0 {
 		rh, _ := ctx.GetValue("responseHeaders")
-		if isMaybeJSON(rh.(map[string][]string)) {
+		if headers, ok := rh.(ep.Headers); ok && isMaybeJSON(headers) {
 			masked, err := maskJSONData(masked["response"], body)
 			if err != nil {
-				log.Printf("Error: %v", err)
+				ctx.Logger().Warn("cannot mask JSON body", "error", err)
 			} else {
 				ctx.ReplaceBodyChunk(masked)
 			}
@@ -133,10 +145,6 @@ func (s *maskerRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, bod
 	return ctx.ContinueRequest()
 }
 
-func (s *maskerRequestProcessor) ProcessResponseTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
 func (s *maskerRequestProcessor) Init(opts *ep.ProcessingOptions, nonFlagArgs []string) error {
 	s.opts = opts
 	return nil
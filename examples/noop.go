@@ -3,6 +3,7 @@ package main
 import ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
 
 type noopRequestProcessor struct {
+	ep.BaseProcessor
 	opts *ep.ProcessingOptions
 }
 
@@ -14,30 +15,6 @@ func (s *noopRequestProcessor) GetOptions() *ep.ProcessingOptions {
 	return s.opts
 }
 
-func (s *noopRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *noopRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body []byte) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *noopRequestProcessor) ProcessRequestTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *noopRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *noopRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, body []byte) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *noopRequestProcessor) ProcessResponseTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
 func (s *noopRequestProcessor) Init(opts *ep.ProcessingOptions, nonFlagArgs []string) error {
 	s.opts = opts
 	return nil
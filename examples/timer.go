@@ -8,6 +8,7 @@ import (
 )
 
 type timerRequestProcessor struct {
+	ep.BaseProcessor
 	opts *ep.ProcessingOptions
 }
 
@@ -19,21 +20,21 @@ func (s *timerRequestProcessor) GetOptions() *ep.ProcessingOptions {
 	return s.opts
 }
 
-func (s *timerRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
-	ctx.OverwriteHeader("x-extproc-started-ns", ep.HeaderValue{RawValue: []byte(strconv.FormatInt(ctx.Started.UnixNano(), 10))})
-
-	return ctx.ContinueRequest()
+func (s *timerRequestProcessor) ProcessedPhases() []int {
+	return []int{
+		ep.REQUEST_PHASE_REQUEST_HEADERS,
+		ep.REQUEST_PHASE_RESPONSE_HEADERS,
+		ep.REQUEST_PHASE_RESPONSE_BODY,
+	}
 }
 
-func (s *timerRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body []byte) error {
-	return ctx.ContinueRequest()
-}
+func (s *timerRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
+	ctx.OverwriteHeader("x-extproc-started-ns", ep.HeaderValue{RawValue: []byte(strconv.FormatInt(ctx.Started.UnixNano(), 10))})
 
-func (s *timerRequestProcessor) ProcessRequestTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
 	return ctx.ContinueRequest()
 }
 
-func (s *timerRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+func (s *timerRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
 	finished := time.Now()
 	duration := time.Since(ctx.Started)
 
@@ -55,10 +56,6 @@ func (s *timerRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, body
 	return ctx.ContinueRequest()
 }
 
-func (s *timerRequestProcessor) ProcessResponseTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
 func (s *timerRequestProcessor) Init(opts *ep.ProcessingOptions, nonFlagArgs []string) error {
 	s.opts = opts
 	return nil
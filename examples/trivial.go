@@ -5,6 +5,7 @@ import (
 )
 
 type trivialRequestProcessor struct {
+	ep.BaseProcessor
 	opts *ep.ProcessingOptions
 }
 
@@ -16,32 +17,16 @@ func (s *trivialRequestProcessor) GetOptions() *ep.ProcessingOptions {
 	return s.opts
 }
 
-func (s *trivialRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
+func (s *trivialRequestProcessor) ProcessRequestHeaders(ctx *ep.RequestContext, headers ep.Headers) error {
 	ctx.AddHeader("x-extproc-request", ep.HeaderValue{RawValue: []byte("seen")})
 	return ctx.ContinueRequest() // returns an error if response malformed
 }
 
-func (s *trivialRequestProcessor) ProcessRequestBody(ctx *ep.RequestContext, body []byte) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *trivialRequestProcessor) ProcessRequestTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
-func (s *trivialRequestProcessor) ProcessResponseHeaders(ctx *ep.RequestContext, headers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
 func (s *trivialRequestProcessor) ProcessResponseBody(ctx *ep.RequestContext, body []byte) error {
 	ctx.AddHeader("x-extproc-response", ep.HeaderValue{RawValue: []byte("seen")})
 	return ctx.ContinueRequest() // returns an error if response malformed
 }
 
-func (s *trivialRequestProcessor) ProcessResponseTrailers(ctx *ep.RequestContext, trailers ep.AllHeaders) error {
-	return ctx.ContinueRequest()
-}
-
 func (s *trivialRequestProcessor) Init(opts *ep.ProcessingOptions, nonFlagArgs []string) error {
 	s.opts = opts
 	return nil
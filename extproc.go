@@ -3,10 +3,12 @@ package extproc
 import (
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"strconv"
 	"time"
 
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -17,42 +19,61 @@ type RequestProcessor interface {
 	GetName() string
 	GetOptions() *ProcessingOptions
 
-	ProcessRequestHeaders(ctx *RequestContext, headers AllHeaders) error
-	ProcessRequestTrailers(ctx *RequestContext, trailers AllHeaders) error
-	ProcessResponseHeaders(ctx *RequestContext, headers AllHeaders) error
-	ProcessResponseTrailers(ctx *RequestContext, trailers AllHeaders) error
+	ProcessRequestHeaders(ctx *RequestContext, headers Headers) error
+	ProcessRequestTrailers(ctx *RequestContext, trailers Headers) error
+	ProcessResponseHeaders(ctx *RequestContext, headers Headers) error
+	ProcessResponseTrailers(ctx *RequestContext, trailers Headers) error
 
 	ProcessResponseBody(ctx *RequestContext, body []byte) error
 	ProcessRequestBody(ctx *RequestContext, body []byte) error
 }
 
 type GenericExtProcServer struct {
-	name      string
-	processor RequestProcessor
-	options   *ProcessingOptions
+	name       string
+	processor  RequestProcessor
+	options    *ProcessingOptions
+	metrics    MetricsRecorder
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
+	// phases dispatched to the processor (see PhaseDeclarer)
+	phases phaseSet
 }
 
 func (s *GenericExtProcServer) Process(srv extprocv3.ExternalProcessor_ProcessServer) error {
 	if s.processor == nil {
-		log.Fatalf("cannot process request stream without `processor` interface")
-	}
-
-	if s.options == nil {
-		s.options = NewDefaultOptions()
+		return status.Error(codes.FailedPrecondition, "cannot process request stream without `processor` interface")
 	}
 
+	logger := s.logger()
 	if s.options.LogStream {
-		log.Printf("Starting request stream in \"%s\"", s.name)
+		logger.Info("starting request stream")
 	}
 
-	rc := &RequestContext{}
 	ctx := srv.Context()
+	rc := &RequestContext{logger: logger, ctx: ctx, streamCtx: ctx, codecOptions: s.options.BodyCodec}
+
+	metrics := s.recorder()
+	metrics.StreamStarted(s.name)
+
+	err := s.process(srv, rc)
+
+	if finisher, ok := s.processor.(StreamFinisher); ok && rc.streamStarted {
+		finisher.OnStreamEnd(rc, err)
+	}
 
+	metrics.StreamFinished(s.name, rc.Duration)
+	s.endStreamSpan(rc, err)
+	return err
+}
+
+func (s *GenericExtProcServer) process(srv extprocv3.ExternalProcessor_ProcessServer, rc *RequestContext) error {
+	ctx := srv.Context()
 	for {
 		select {
 		case <-ctx.Done():
 			if s.options.LogStream {
-				log.Printf("Request stream terminated in \"%s\"", s.name)
+				rc.Logger().Info("request stream terminated", "error", ctx.Err())
 			}
 			return ctx.Err()
 
@@ -61,6 +82,9 @@ func (s *GenericExtProcServer) Process(srv extprocv3.ExternalProcessor_ProcessSe
 
 		req, err := srv.Recv()
 		if err == io.EOF {
+			if s.options.LogStream {
+				rc.Logger().Info("request stream finished", "duration", rc.Duration)
+			}
 			return nil
 		}
 		if err != nil {
@@ -73,151 +97,259 @@ func (s *GenericExtProcServer) Process(srv extprocv3.ExternalProcessor_ProcessSe
 		// RequestHeaders phase processing.
 		_ = rc.ResetPhase()
 
-		resp, err := s.processPhase(req, s.processor, rc)
+		resps, err := s.processPhase(req, s.processor, rc)
 		if err != nil {
-			log.Printf("Phase processing error %v", err)
-		} else if resp == nil {
-			log.Printf("Phase processing did not define a response")
+			rc.Logger().Error("terminating stream after phase processing error", "phase", PhaseName(rc.phase), "error", err)
+			return status.Convert(err).Err()
+		} else if len(resps) == 0 && !rc.FullDuplexBody(rc.phase) {
+			rc.Logger().Warn("phase processing did not define a response", "phase", PhaseName(rc.phase))
 			// TODO: what here?
 		} else {
-			if s.options.LogPhases {
-				log.Printf("Sending ProcessingResponse: %v \n", resp)
-			}
-			if err := srv.Send(resp); err != nil {
-				log.Printf("Send error %v", err)
+			// full duplex body phases send any number of responses
+			for _, resp := range resps {
+				if s.options.LogPhases {
+					rc.Logger().Info("sending processing response", "phase", PhaseName(rc.phase), "response", resp)
+				}
+				if err := srv.Send(resp); err != nil {
+					rc.Logger().Error("send error", "phase", PhaseName(rc.phase), "error", err)
+				}
 			}
 		}
 
 	} // end for over stream messages
 }
 
-func (s *GenericExtProcServer) processPhase(procReq *extprocv3.ProcessingRequest, processor RequestProcessor, rc *RequestContext) (*extprocv3.ProcessingResponse, error) {
+func (s *GenericExtProcServer) processPhase(procReq *extprocv3.ProcessingRequest, processor RequestProcessor, rc *RequestContext) ([]*extprocv3.ProcessingResponse, error) {
 	if rc == nil {
-		log.Printf("WARNING: RequestContext is undefined (nil)\n")
+		return nil, errors.New("RequestContext is undefined (nil)")
 	}
 
-	var (
-		ps  time.Time
-		err error
-	)
+	// envoy attributes (if configured) accumulate across phases
+	rc.mergeAttributes(procReq.Attributes)
+	if procReq.MetadataContext != nil {
+		rc.metadata = procReq.MetadataContext
+	}
+
+	// handler calls the processor's method for the phase
+	var handler func() error
 
 	phase := REQUEST_PHASE_UNDETERMINED
 
 	switch req := procReq.Request.(type) {
 	case *extprocv3.ProcessingRequest_RequestHeaders:
 		phase = REQUEST_PHASE_REQUEST_HEADERS
-		if s.options.LogPhases {
-			log.Printf("Processing Request Headers: %v \n", req)
-		}
 		h := req.RequestHeaders
 
 		// initialize request context (requires _not_ skipping request headers)
 		_ = initReqCtx(rc, h.Headers)
+		rc.initProcessingMode(s.options.ProcessingMode)
+		rc.initLogger(s.logger())
+		s.startStreamSpan(rc, h.Headers)
 		rc.EndOfStream = h.EndOfStream
+		rc.requestEncodings = contentEncodings(h.Headers)
 
-		ps = time.Now()
-		err = processor.ProcessRequestHeaders(rc, rc.AllHeaders)
-		// TODO: _Could_ stack processors internally, e.g.
-		//
-		// 		for _, p := range s.processors { err = p.ProcessRequestHeaders(...); if err != nil { break } }
-		//
-		// This might get confusing though? Also response phase order
-		// would need to be inverted.
-		//
-		// In any case, it would be a distinctly different behavior than
-		// stacking ExtProcs in envoy. Until there is a need for this,
-		// it's much easier to reason about one processor per ExtProc.
-		// Users can "stack" whatever behaviors they like in the processors
-		// themselves anyway.
-		rc.Duration += time.Since(ps)
+		// NOTE: use Chain to stack processors within one ExtProc
+		handler = func() error { return processor.ProcessRequestHeaders(rc, rc.Headers) }
 
 	case *extprocv3.ProcessingRequest_RequestBody:
 		phase = REQUEST_PHASE_REQUEST_BODY
-		if s.options.LogPhases {
-			log.Printf("Processing Request Body: %v \n", req)
-		}
 		b := req.RequestBody
 		rc.EndOfStream = b.EndOfStream
+		rc.chunk = b.Body
 
-		ps = time.Now()
-		err = processor.ProcessRequestBody(rc, b.Body)
-		rc.Duration += time.Since(ps)
+		handler = func() error {
+			body, err := rc.decodeChunk(b.Body)
+			if err != nil {
+				return err
+			}
+			return processor.ProcessRequestBody(rc, body)
+		}
+		if complete := s.bodyCompletion(rc, processor, phase); complete != nil {
+			handler = func() error { return s.bufferBody(rc, b.Body, complete) }
+		}
 
 	case *extprocv3.ProcessingRequest_RequestTrailers:
 		phase = REQUEST_PHASE_REQUEST_TRAILERS
-		if s.options.LogPhases {
-			log.Printf("Processing Request Trailers: %v \n", req)
-		}
 		ts := req.RequestTrailers
 
-		// TODO: err check
-		trailers, _ := genHeaders(ts.Trailers)
+		trailers := newHeaders(ts.Trailers)
 
-		ps = time.Now()
-		err = processor.ProcessRequestTrailers(rc, trailers)
-		rc.Duration += time.Since(ps)
+		handler = func() error { return processor.ProcessRequestTrailers(rc, trailers) }
+		if complete := s.bodyCompletion(rc, processor, REQUEST_PHASE_REQUEST_BODY); complete != nil {
+			handler = func() error {
+				if err := s.completeBufferedBody(rc, complete); err != nil {
+					return err
+				}
+				return processor.ProcessRequestTrailers(rc, trailers)
+			}
+		}
 
 	case *extprocv3.ProcessingRequest_ResponseHeaders:
 		phase = REQUEST_PHASE_RESPONSE_HEADERS
-		if s.options.LogPhases {
-			log.Printf("Processing Response Headers: %v \n", req)
-		}
 		hs := req.ResponseHeaders
 		rc.EndOfStream = hs.EndOfStream
+		rc.responseEncodings = contentEncodings(hs.Headers)
 
 		// _response_ headers
 
-		headers, _ := genHeaders(hs.Headers)
+		headers := newHeaders(hs.Headers)
+		rc.responseHeaders = headers
 
-		ps = time.Now()
-		err = processor.ProcessResponseHeaders(rc, headers)
-		rc.Duration += time.Since(ps)
-
-		if s.options.UpdateExtProcHeader {
-			rc.AppendHeader("x-extproc-names", HeaderValue{RawValue: []byte(s.name)})
-		}
-		if rc.EndOfStream && s.options.UpdateDurationHeader {
-			rc.AppendHeader("x-extproc-duration-ns", HeaderValue{RawValue: []byte(strconv.FormatInt(rc.Duration.Nanoseconds(), 10))})
-		}
+		handler = func() error { return processor.ProcessResponseHeaders(rc, headers) }
 
 	case *extprocv3.ProcessingRequest_ResponseBody:
 		phase = REQUEST_PHASE_RESPONSE_BODY
-		if s.options.LogPhases {
-			log.Printf("Processing Response Body: %v \n", req)
-		}
 		b := req.ResponseBody
 		rc.EndOfStream = b.EndOfStream
+		rc.chunk = b.Body
 
-		ps = time.Now()
-		err = processor.ProcessResponseBody(rc, b.Body)
-		rc.Duration += time.Since(ps)
-
-		if rc.EndOfStream && s.options.UpdateDurationHeader {
-			rc.AppendHeader("x-extproc-duration-ns", HeaderValue{RawValue: []byte(strconv.FormatInt(rc.Duration.Nanoseconds(), 10))})
+		handler = func() error {
+			body, err := rc.decodeChunk(b.Body)
+			if err != nil {
+				return err
+			}
+			return processor.ProcessResponseBody(rc, body)
+		}
+		if complete := s.bodyCompletion(rc, processor, phase); complete != nil {
+			handler = func() error { return s.bufferBody(rc, b.Body, complete) }
 		}
 
 	case *extprocv3.ProcessingRequest_ResponseTrailers:
 		phase = REQUEST_PHASE_RESPONSE_TRAILERS
-		if s.options.LogPhases {
-			log.Printf("Processing Response Trailers: %v \n", req)
-		}
 		ts := req.ResponseTrailers
 
-		trailers, _ := genHeaders(ts.Trailers)
+		trailers := newHeaders(ts.Trailers)
 
-		ps = time.Now()
-		err = processor.ProcessResponseTrailers(rc, trailers)
-		rc.Duration += time.Since(ps)
+		handler = func() error { return processor.ProcessResponseTrailers(rc, trailers) }
+		if complete := s.bodyCompletion(rc, processor, REQUEST_PHASE_RESPONSE_BODY); complete != nil {
+			handler = func() error {
+				if err := s.completeBufferedBody(rc, complete); err != nil {
+					return err
+				}
+				return processor.ProcessResponseTrailers(rc, trailers)
+			}
+		}
 
 	default:
+	}
+	rc.phase = phase
+
+	// trailer changes made in earlier phases
+	if rc.isTrailersPhase() {
+		rc.applyTrailerMutation()
+	}
+
+	var err error
+	before := rc.Duration
+	if handler == nil {
 		if s.options.LogPhases {
-			log.Printf("Unknown Request type: %v\n", req)
+			rc.Logger().Warn("unknown request type", "request", procReq)
 		}
 		err = errors.New("unknown request type")
+	} else {
+		if s.options.LogPhases {
+			rc.Logger().Info("processing phase", "phase", PhaseName(phase), "request", procReq)
+		}
+		err = s.runPhase(rc, processor, handler)
+	}
+
+	// SDK-defined response headers
+	switch phase {
+	case REQUEST_PHASE_RESPONSE_HEADERS:
+		if s.options.UpdateExtProcHeader {
+			rc.AppendHeader("x-extproc-names", HeaderValue{RawValue: []byte(s.name)})
+		}
+		if rc.EndOfStream && s.options.UpdateDurationHeader {
+			rc.AppendHeader("x-extproc-duration-ns", HeaderValue{RawValue: []byte(strconv.FormatInt(rc.Duration.Nanoseconds(), 10))})
+		}
+
+	case REQUEST_PHASE_RESPONSE_BODY:
+		if rc.EndOfStream && s.options.UpdateDurationHeader {
+			rc.AppendHeader("x-extproc-duration-ns", HeaderValue{RawValue: []byte(strconv.FormatInt(rc.Duration.Nanoseconds(), 10))})
+		}
+
+	default:
+	}
+
+	rc.Logger().Debug("phase processed", "phase", PhaseName(phase), "duration", rc.Duration-before, "error", err)
+
+	metrics := s.recorder()
+	metrics.PhaseProcessed(s.name, phase, rc.Duration-before)
+	herr := err
+	if err != nil {
+		metrics.HandlerError(s.name, phase)
+		if err = s.handleError(rc, phase, err); err != nil {
+			s.endPhaseSpan(rc, nil, herr)
+			return nil, err
+		}
+	}
+
+	switch phase {
+	case REQUEST_PHASE_REQUEST_BODY, REQUEST_PHASE_RESPONSE_BODY:
+		if !rc.cancelled() {
+			rc.trackBodySize()
+		}
+	}
+
+	resps, err := rc.GetResponses(phase)
+	var resp *extprocv3.ProcessingResponse
+	if len(resps) > 0 {
+		resp = resps[0]
+	}
+	if ir := resp.GetImmediateResponse(); ir != nil {
+		metrics.ImmediateResponse(s.name, phase, int32(ir.GetStatus().GetCode()))
+	}
+	s.endPhaseSpan(rc, resp, herr)
+	return resps, err
+}
+
+// runPhase calls the phase handler, along with any lifecycle hooks the
+// processor implements, timing the processing in rc.Duration.
+func (s *GenericExtProcServer) runPhase(rc *RequestContext, processor RequestProcessor, handler func() error) (err error) {
+	ps := s.startPhaseSpan(rc)
+	defer func() { rc.Duration += time.Since(ps) }()
+
+	if !rc.streamStarted {
+		rc.streamStarted = true
+		if starter, ok := processor.(StreamStarter); ok {
+			if err = starter.OnStreamStart(rc); err != nil {
+				return err
+			}
+		}
+	}
+
+	if pre, ok := processor.(PhasePreprocessor); ok {
+		if err = pre.PreprocessPhase(rc, rc.phase); err != nil {
+			return err
+		}
+	}
+
+	if s.phases[rc.phase] {
+		err = handler()
+	} else {
+		err = rc.ContinueRequest()
 	}
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return rc.GetResponse(phase)
+	if post, ok := processor.(PhasePostprocessor); ok {
+		err = post.PostprocessPhase(rc, rc.phase)
+	}
+	return err
+}
+
+func (s *GenericExtProcServer) logger() *slog.Logger {
+	logger := slog.Default()
+	if s.options != nil && s.options.Logger != nil {
+		logger = s.options.Logger
+	}
+	return logger.With("processor", s.name)
+}
+
+func (s *GenericExtProcServer) recorder() MetricsRecorder {
+	if s.metrics == nil {
+		return noopMetrics{}
+	}
+	return s.metrics
 }
@@ -0,0 +1,302 @@
+package extproctest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+var requestIDs atomic.Int64
+
+// message is one side (request or response) of an exchange.
+type message struct {
+	headers  []*corev3.HeaderValue
+	chunks   [][]byte
+	trailers []*corev3.HeaderValue
+}
+
+func (m *message) setPseudo(name, value string) {
+	for _, h := range m.headers {
+		if h.Key == name {
+			h.RawValue = []byte(value)
+			return
+		}
+	}
+	m.headers = append(m.headers, &corev3.HeaderValue{Key: name, RawValue: []byte(value)})
+}
+
+// Exchange describes a request, and optionally its response, to send
+// through a processor. Methods configure the request until Response is
+// called, and the response after.
+type Exchange struct {
+	h        *Harness
+	request  message
+	response *message
+}
+
+// Request starts an exchange for a request with method and path (which
+// may include a query). The request has :scheme "http", :authority
+// "extproctest" and a generated x-request-id, unless set with Header.
+func (h *Harness) Request(method, path string) *Exchange {
+	e := &Exchange{h: h}
+	e.request.setPseudo(":scheme", "http")
+	e.request.setPseudo(":authority", "extproctest")
+	e.request.setPseudo(":method", method)
+	e.request.setPseudo(":path", path)
+	return e
+}
+
+func (e *Exchange) current() *message {
+	if e.response != nil {
+		return e.response
+	}
+	return &e.request
+}
+
+// Header adds a header; repeated calls for a name add repeated headers,
+// except for pseudo-headers (":path", ...) which are replaced.
+func (e *Exchange) Header(name, value string) *Exchange {
+	m := e.current()
+	if strings.HasPrefix(name, ":") {
+		m.setPseudo(name, value)
+	} else {
+		m.headers = append(m.headers, &corev3.HeaderValue{Key: name, RawValue: []byte(value)})
+	}
+	return e
+}
+
+// Body adds body chunks, each sent in its own body phase.
+func (e *Exchange) Body(chunks ...string) *Exchange {
+	m := e.current()
+	for _, c := range chunks {
+		m.chunks = append(m.chunks, []byte(c))
+	}
+	return e
+}
+
+// Trailer adds a trailer.
+func (e *Exchange) Trailer(name, value string) *Exchange {
+	m := e.current()
+	m.trailers = append(m.trailers, &corev3.HeaderValue{Key: name, RawValue: []byte(value)})
+	return e
+}
+
+// Response adds a response with status to the exchange; the methods
+// called after it configure the response.
+func (e *Exchange) Response(status int) *Exchange {
+	e.response = &message{}
+	e.response.setPseudo(":status", strconv.Itoa(status))
+	return e
+}
+
+// phases returns the processing requests envoy would send for the
+// exchange, in order.
+func (e *Exchange) phases() []*extprocv3.ProcessingRequest {
+	request := e.request
+	hasID := false
+	for _, h := range request.headers {
+		hasID = hasID || strings.EqualFold(h.Key, "x-request-id")
+	}
+	if !hasID {
+		id := fmt.Sprintf("extproctest-%d", requestIDs.Add(1))
+		request.headers = append(request.headers, &corev3.HeaderValue{Key: "x-request-id", RawValue: []byte(id)})
+	}
+
+	phases := messagePhases(&request, false)
+	if e.response != nil {
+		phases = append(phases, messagePhases(e.response, true)...)
+	}
+	return phases
+}
+
+func messagePhases(m *message, response bool) []*extprocv3.ProcessingRequest {
+	var phases []*extprocv3.ProcessingRequest
+
+	headers := &extprocv3.HttpHeaders{
+		Headers:     &corev3.HeaderMap{Headers: m.headers},
+		EndOfStream: len(m.chunks) == 0 && len(m.trailers) == 0,
+	}
+	if response {
+		phases = append(phases, &extprocv3.ProcessingRequest{
+			Request: &extprocv3.ProcessingRequest_ResponseHeaders{ResponseHeaders: headers},
+		})
+	} else {
+		phases = append(phases, &extprocv3.ProcessingRequest{
+			Request: &extprocv3.ProcessingRequest_RequestHeaders{RequestHeaders: headers},
+		})
+	}
+
+	for i, chunk := range m.chunks {
+		body := &extprocv3.HttpBody{
+			Body:        chunk,
+			EndOfStream: i == len(m.chunks)-1 && len(m.trailers) == 0,
+		}
+		if response {
+			phases = append(phases, &extprocv3.ProcessingRequest{
+				Request: &extprocv3.ProcessingRequest_ResponseBody{ResponseBody: body},
+			})
+		} else {
+			phases = append(phases, &extprocv3.ProcessingRequest{
+				Request: &extprocv3.ProcessingRequest_RequestBody{RequestBody: body},
+			})
+		}
+	}
+
+	if len(m.trailers) > 0 {
+		trailers := &extprocv3.HttpTrailers{Trailers: &corev3.HeaderMap{Headers: m.trailers}}
+		if response {
+			phases = append(phases, &extprocv3.ProcessingRequest{
+				Request: &extprocv3.ProcessingRequest_ResponseTrailers{ResponseTrailers: trailers},
+			})
+		} else {
+			phases = append(phases, &extprocv3.ProcessingRequest{
+				Request: &extprocv3.ProcessingRequest_RequestTrailers{RequestTrailers: trailers},
+			})
+		}
+	}
+
+	return phases
+}
+
+// Run sends the exchange through the processor the way envoy would: phase
+// by phase, waiting for each phase's response. As in envoy, an immediate
+// response ends the exchange, and a CONTINUE_AND_REPLACE headers response
+// skips the body and trailers phases that follow it. Body chunks of a
+// FULL_DUPLEX_STREAMED body (per the processor's ProcessingMode) are sent
+// without waiting, and responses are read until the body ends. Phases are
+// otherwise sent as described, regardless of the processing mode.
+//
+// Errors of the stream, e.g. from a processor's phase error, are returned
+// in the Result; failures to run the exchange fail the test. The Result's
+// Simulation applies the responses as envoy would.
+func (e *Exchange) Run() *Result {
+	t := e.h.t
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.h.Timeout)
+	defer cancel()
+
+	stream, err := e.h.client.Process(ctx)
+	if err != nil {
+		t.Fatalf("cannot start stream: %v", err)
+	}
+
+	res := &Result{t: t}
+	phases := e.phases()
+	skip := kNoDirection // direction replaced at headers
+	for i := 0; i < len(phases); i++ {
+		req := phases[i]
+		phase := phaseOf(req)
+		if direction(phase) == skip {
+			continue
+		}
+
+		// full duplex bodies: send all chunks (and trailers), then read
+		// until the body (or trailers phase) ends
+		if isBodyPhase(phase) && e.h.fullDuplex(phase) {
+			var sent []*PhaseResult
+			for ; i < len(phases) && direction(phaseOf(phases[i])) == direction(phase) && !isHeadersPhase(phaseOf(phases[i])); i++ {
+				if err := stream.Send(phases[i]); err != nil {
+					t.Fatalf("cannot send %s phase: %v", ep.PhaseName(phaseOf(phases[i])), err)
+				}
+				sent = append(sent, &PhaseResult{Phase: phaseOf(phases[i]), Request: phases[i]})
+			}
+			i--
+			res.Phases = append(res.Phases, sent...)
+			if !res.receiveDuplex(stream, sent) {
+				break
+			}
+			continue
+		}
+
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("cannot send %s phase: %v", ep.PhaseName(phase), err)
+		}
+		pr := &PhaseResult{Phase: phase, Request: req}
+		res.Phases = append(res.Phases, pr)
+
+		resp, err := stream.Recv()
+		if err != nil {
+			res.Err = err
+			break
+		}
+		pr.Responses = append(pr.Responses, resp)
+
+		if resp.GetImmediateResponse() != nil {
+			break
+		}
+		if pr.Common().GetStatus() == extprocv3.CommonResponse_CONTINUE_AND_REPLACE {
+			skip = direction(phase)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("cannot close stream: %v", err)
+	}
+	if res.Err == nil {
+		// wait for the processor to finish the stream
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				res.Err = err
+				break
+			}
+			t.Errorf("unexpected %T response after the exchange", resp.Response)
+		}
+	}
+
+	res.Simulation = e.h.simulate(res.Phases)
+	return res
+}
+
+// receiveDuplex reads the responses to full duplex body phases (and
+// trailers) already sent, attaching body responses to the last body chunk.
+// It reports whether the exchange may continue.
+func (res *Result) receiveDuplex(stream extprocv3.ExternalProcessor_ProcessClient, sent []*PhaseResult) bool {
+	var body, trailers *PhaseResult
+	for _, pr := range sent {
+		if isBodyPhase(pr.Phase) {
+			body = pr
+		} else {
+			trailers = pr
+		}
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			res.Err = err
+			return false
+		}
+		if resp.GetImmediateResponse() != nil {
+			body.Responses = append(body.Responses, resp)
+			return false
+		}
+
+		if trailers != nil && (resp.GetRequestTrailers() != nil || resp.GetResponseTrailers() != nil) {
+			trailers.Responses = append(trailers.Responses, resp)
+			return true
+		}
+		body.Responses = append(body.Responses, resp)
+		if trailers == nil && streamedResponse(resp).GetEndOfStream() {
+			return true
+		}
+	}
+}
+
+func streamedResponse(resp *extprocv3.ProcessingResponse) *extprocv3.StreamedBodyResponse {
+	if b := resp.GetRequestBody(); b != nil {
+		return b.GetResponse().GetBodyMutation().GetStreamedResponse()
+	}
+	return resp.GetResponseBody().GetResponse().GetBodyMutation().GetStreamedResponse()
+}
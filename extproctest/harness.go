@@ -0,0 +1,127 @@
+// Package extproctest runs ExtProc processors in-process for tests. A
+// Harness serves a processor over an in-memory gRPC connection and plays
+// envoy's part: an Exchange describes a request (and response) to send
+// through the processor phase by phase, and its Result holds the
+// processor's responses, decoded, with assertion helpers.
+//
+//	h := extproctest.New(t, &myProcessor{})
+//	res := h.Request("POST", "/things").
+//		Header("content-type", "application/json").
+//		Body(`{"name":"thing"}`).
+//		Response(200).
+//		Header("content-type", "application/json").
+//		Body(`{"id":1}`).
+//		Run()
+//	res.AssertNoError()
+//	res.AssertHeader(ep.REQUEST_PHASE_REQUEST_HEADERS, "x-thing", "1")
+package extproctest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	mutationrulesv3 "github.com/envoyproxy/go-control-plane/envoy/config/common/mutation_rules/v3"
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+const (
+	kBufferSize     = 1 << 20
+	kDefaultTimeout = 10 * time.Second
+)
+
+// Harness serves a processor in-process, over a bufconn listener, for the
+// duration of a test.
+type Harness struct {
+	t         testing.TB
+	processor ep.RequestProcessor
+	client    extprocv3.ExternalProcessorClient
+
+	// Timeout bounds each Exchange's Run (10s by default).
+	Timeout time.Duration
+
+	// MutationRules mirrors the filter's mutation_rules for the Result's
+	// Simulation (envoy's defaults when nil).
+	MutationRules *mutationrulesv3.HeaderMutationRules
+}
+
+// New starts a Server for processor, configured with opts, and connects
+// to it. Both are stopped when the test finishes.
+func New(t testing.TB, processor ep.RequestProcessor, opts ...ep.Option) *Harness {
+	t.Helper()
+
+	server, err := ep.NewServer(processor, opts...)
+	if err != nil {
+		t.Fatalf("cannot create server: %v", err)
+	}
+
+	lis := bufconn.Listen(kBufferSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, lis)
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///extproctest",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		cancel()
+		t.Fatalf("cannot connect to server: %v", err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		if err := <-served; err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			t.Errorf("server error: %v", err)
+		}
+	})
+
+	return &Harness{
+		t:         t,
+		processor: processor,
+		client:    extprocv3.NewExternalProcessorClient(conn),
+		Timeout:   kDefaultTimeout,
+	}
+}
+
+// fullDuplex reports whether the processor's options stream the body of
+// phase in FULL_DUPLEX_STREAMED mode.
+func (h *Harness) fullDuplex(phase int) bool {
+	opts := h.processor.GetOptions()
+	if opts == nil || opts.ProcessingMode == nil {
+		return false
+	}
+	mode := opts.ProcessingMode.GetRequestBodyMode()
+	if phase == ep.REQUEST_PHASE_RESPONSE_BODY {
+		mode = opts.ProcessingMode.GetResponseBodyMode()
+	}
+	return mode == filterv3.ProcessingMode_FULL_DUPLEX_STREAMED
+}
+
+// simulate applies the responses of an exchange's phases as envoy would.
+func (h *Harness) simulate(phases []*PhaseResult) *ep.Simulation {
+	sim := &ep.Simulation{MutationRules: h.MutationRules}
+	if opts := h.processor.GetOptions(); opts != nil {
+		sim.ProcessingMode = opts.ProcessingMode
+	}
+	for _, pr := range phases {
+		if err := sim.Apply(pr.Request, pr.Responses...); err != nil {
+			h.t.Logf("%s: %v", ep.PhaseName(pr.Phase), err)
+			break
+		}
+	}
+	return sim
+}
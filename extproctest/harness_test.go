@@ -0,0 +1,223 @@
+package extproctest_test
+
+import (
+	"slices"
+	"testing"
+
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// phaseRecorder records the phases (and their end of stream flags) a
+// processor is called for.
+type phaseRecorder struct {
+	ep.BaseProcessor
+	phases []string
+}
+
+func (p *phaseRecorder) GetName() string                   { return "recorder" }
+func (p *phaseRecorder) GetOptions() *ep.ProcessingOptions { return nil }
+
+func (p *phaseRecorder) PreprocessPhase(ctx *ep.RequestContext, phase int) error {
+	name := ep.PhaseName(phase)
+	if ctx.EndOfStream {
+		name += "(eos)"
+	}
+	p.phases = append(p.phases, name)
+	return nil
+}
+
+func TestRunSendsPhasesInOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		exchange func(*extproctest.Harness) *extproctest.Exchange
+		want     []string
+	}{
+		{
+			name: "headers only",
+			exchange: func(h *extproctest.Harness) *extproctest.Exchange {
+				return h.Request("GET", "/")
+			},
+			want: []string{"request_headers(eos)"},
+		},
+		{
+			name: "bodies",
+			exchange: func(h *extproctest.Harness) *extproctest.Exchange {
+				return h.Request("POST", "/").Body("a", "b").Response(200).Body("c")
+			},
+			want: []string{"request_headers", "request_body", "request_body(eos)", "response_headers", "response_body(eos)"},
+		},
+		{
+			name: "trailers",
+			exchange: func(h *extproctest.Harness) *extproctest.Exchange {
+				return h.Request("POST", "/").Body("a").Trailer("t", "1").Response(204).Trailer("t", "2")
+			},
+			want: []string{"request_headers", "request_body", "request_trailers", "response_headers", "response_trailers"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &phaseRecorder{}
+			res := tt.exchange(extproctest.New(t, p)).Run()
+			res.AssertContinued()
+			if !slices.Equal(p.phases, tt.want) {
+				t.Errorf("phases %v, want %v", p.phases, tt.want)
+			}
+			if len(res.Phases) != len(tt.want) {
+				t.Errorf("got %d phase results, want %d", len(res.Phases), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestRunRequestHeaders(t *testing.T) {
+	var got ep.Headers
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "headers",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			got = headers
+			return ctx.ContinueRequest()
+		},
+	})
+	h.Request("PUT", "/things?x=1").Header("x-a", "1").Header("x-a", "2").Header(":authority", "example.com").Run().AssertContinued()
+
+	if v := got.Pseudo("method"); v != "PUT" {
+		t.Errorf(":method %q", v)
+	}
+	if v := got.Pseudo("path"); v != "/things?x=1" {
+		t.Errorf(":path %q", v)
+	}
+	if v := got.Pseudo("authority"); v != "example.com" {
+		t.Errorf(":authority %q", v)
+	}
+	if v := got.Values("x-a"); !slices.Equal(v, []string{"1", "2"}) {
+		t.Errorf("x-a %q", v)
+	}
+	if !got.Has("x-request-id") {
+		t.Error("no x-request-id generated")
+	}
+}
+
+func TestRunImmediateResponseEndsExchange(t *testing.T) {
+	p := &phaseRecorder{}
+	h := extproctest.New(t, &cancelling{phaseRecorder: p})
+	res := h.Request("POST", "/").Body("a", "b").Response(200).Body("c").Run()
+
+	res.AssertNoError()
+	res.AssertImmediateResponse(403)
+	res.AssertDownstreamHeader(":status", "403")
+	res.AssertDownstreamBody("denied")
+	if !slices.Equal(p.phases, []string{"request_headers", "request_body"}) {
+		t.Errorf("phases %v", p.phases)
+	}
+}
+
+type cancelling struct {
+	*phaseRecorder
+}
+
+func (p *cancelling) ProcessRequestBody(ctx *ep.RequestContext, body []byte) error {
+	return ctx.CancelRequest(403, nil, "denied")
+}
+
+func TestRunContinueAndReplaceSkipsBody(t *testing.T) {
+	calls := 0
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "replace",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			if err := ctx.ReplaceBodyAtHeaders([]byte("new")); err != nil {
+				return err
+			}
+			return ctx.ContinueRequest()
+		},
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			calls++
+			return ctx.ContinueRequest()
+		},
+	})
+	res := h.Request("POST", "/").Body("old", "er").Trailer("t", "1").Response(200).Body("ok").Run()
+
+	res.AssertContinued()
+	if calls != 0 {
+		t.Errorf("request body phase called %d times after CONTINUE_AND_REPLACE", calls)
+	}
+	if res.Phase(ep.REQUEST_PHASE_REQUEST_TRAILERS) != nil {
+		t.Error("request trailers sent after CONTINUE_AND_REPLACE")
+	}
+	res.AssertBody(ep.REQUEST_PHASE_REQUEST_BODY, "new")
+	res.AssertUpstreamBody("new")
+	res.AssertDownstreamBody("ok")
+}
+
+// upper streams each chunk back upper cased, in two pieces.
+type upper struct {
+	ep.BaseProcessor
+}
+
+func (p *upper) GetName() string { return "upper" }
+
+func (p *upper) GetOptions() *ep.ProcessingOptions {
+	opts := ep.NewDefaultOptions()
+	opts.ProcessingMode = &filterv3.ProcessingMode{
+		RequestHeaderMode:  filterv3.ProcessingMode_SEND,
+		ResponseHeaderMode: filterv3.ProcessingMode_SEND,
+		ResponseBodyMode:   filterv3.ProcessingMode_FULL_DUPLEX_STREAMED,
+	}
+	return opts
+}
+
+func (p *upper) ProcessResponseBody(ctx *ep.RequestContext, body []byte) error {
+	for i, b := range body {
+		if b >= 'a' && b <= 'z' {
+			body[i] = b - 'a' + 'A'
+		}
+	}
+	half := len(body) / 2
+	if err := ctx.EmitBodyChunk(body[:half], false); err != nil {
+		return err
+	}
+	if err := ctx.EmitBodyChunk(body[half:], ctx.EndOfStream); err != nil {
+		return err
+	}
+	return ctx.ContinueRequest()
+}
+
+func TestRunFullDuplexBody(t *testing.T) {
+	h := extproctest.New(t, &upper{})
+	res := h.Request("GET", "/").Response(200).Body("ab", "cd", "ef").Run()
+
+	res.AssertContinued()
+	res.AssertBody(ep.REQUEST_PHASE_RESPONSE_BODY, "ABCDEF")
+	res.AssertDownstreamBody("ABCDEF")
+	responses := 0
+	for _, pr := range res.Phases {
+		if pr.Phase == ep.REQUEST_PHASE_RESPONSE_BODY {
+			responses += len(pr.Responses)
+		}
+	}
+	if responses != 6 {
+		t.Errorf("got %d streamed responses, want 6", responses)
+	}
+}
+
+func TestRunStreamError(t *testing.T) {
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name:    "terminate",
+		Options: &ep.ProcessingOptions{ErrorPolicy: ep.TerminateOnError(codes.Unavailable)},
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			return status.Error(codes.Internal, "boom")
+		},
+	})
+	res := h.Request("GET", "/").Response(200).Run()
+
+	if status.Code(res.Err) != codes.Unavailable {
+		t.Errorf("stream error %v, want code Unavailable", res.Err)
+	}
+	if len(res.Phases) != 1 {
+		t.Errorf("got %d phases after the stream ended, want 1", len(res.Phases))
+	}
+}
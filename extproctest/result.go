@@ -0,0 +1,395 @@
+package extproctest
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+// directions of phases, e.g. to skip the rest of a replaced body
+const (
+	kNoDirection = iota
+	kRequestDirection
+	kResponseDirection
+)
+
+func phaseOf(req *extprocv3.ProcessingRequest) int {
+	switch req.Request.(type) {
+	case *extprocv3.ProcessingRequest_RequestHeaders:
+		return ep.REQUEST_PHASE_REQUEST_HEADERS
+	case *extprocv3.ProcessingRequest_RequestBody:
+		return ep.REQUEST_PHASE_REQUEST_BODY
+	case *extprocv3.ProcessingRequest_RequestTrailers:
+		return ep.REQUEST_PHASE_REQUEST_TRAILERS
+	case *extprocv3.ProcessingRequest_ResponseHeaders:
+		return ep.REQUEST_PHASE_RESPONSE_HEADERS
+	case *extprocv3.ProcessingRequest_ResponseBody:
+		return ep.REQUEST_PHASE_RESPONSE_BODY
+	case *extprocv3.ProcessingRequest_ResponseTrailers:
+		return ep.REQUEST_PHASE_RESPONSE_TRAILERS
+	default:
+		return ep.REQUEST_PHASE_UNDETERMINED
+	}
+}
+
+func direction(phase int) int {
+	switch phase {
+	case ep.REQUEST_PHASE_REQUEST_HEADERS, ep.REQUEST_PHASE_REQUEST_BODY, ep.REQUEST_PHASE_REQUEST_TRAILERS:
+		return kRequestDirection
+	case ep.REQUEST_PHASE_RESPONSE_HEADERS, ep.REQUEST_PHASE_RESPONSE_BODY, ep.REQUEST_PHASE_RESPONSE_TRAILERS:
+		return kResponseDirection
+	default:
+		return kNoDirection
+	}
+}
+
+func isHeadersPhase(phase int) bool {
+	return phase == ep.REQUEST_PHASE_REQUEST_HEADERS || phase == ep.REQUEST_PHASE_RESPONSE_HEADERS
+}
+
+func isBodyPhase(phase int) bool {
+	return phase == ep.REQUEST_PHASE_REQUEST_BODY || phase == ep.REQUEST_PHASE_RESPONSE_BODY
+}
+
+// PhaseResult is a phase sent to the processor and its responses.
+type PhaseResult struct {
+	Phase   int // REQUEST_PHASE_*
+	Request *extprocv3.ProcessingRequest
+
+	// Responses usually holds one response; full duplex body phases get
+	// any number, all attached to the last body chunk.
+	Responses []*extprocv3.ProcessingResponse
+}
+
+// Common returns the common response of the phase's (first) headers or
+// body response, if any.
+func (p *PhaseResult) Common() *extprocv3.CommonResponse {
+	if len(p.Responses) == 0 {
+		return nil
+	}
+	switch r := p.Responses[0].Response.(type) {
+	case *extprocv3.ProcessingResponse_RequestHeaders:
+		return r.RequestHeaders.GetResponse()
+	case *extprocv3.ProcessingResponse_ResponseHeaders:
+		return r.ResponseHeaders.GetResponse()
+	case *extprocv3.ProcessingResponse_RequestBody:
+		return r.RequestBody.GetResponse()
+	case *extprocv3.ProcessingResponse_ResponseBody:
+		return r.ResponseBody.GetResponse()
+	default:
+		return nil
+	}
+}
+
+// HeaderMutation returns the phase's header mutation, from its common
+// response or, in trailers phases, its trailers response.
+func (p *PhaseResult) HeaderMutation() *extprocv3.HeaderMutation {
+	if len(p.Responses) == 0 {
+		return nil
+	}
+	switch r := p.Responses[0].Response.(type) {
+	case *extprocv3.ProcessingResponse_RequestTrailers:
+		return r.RequestTrailers.GetHeaderMutation()
+	case *extprocv3.ProcessingResponse_ResponseTrailers:
+		return r.ResponseTrailers.GetHeaderMutation()
+	default:
+		return p.Common().GetHeaderMutation()
+	}
+}
+
+// BodyMutation returns the phase's body mutation, if any.
+func (p *PhaseResult) BodyMutation() *extprocv3.BodyMutation {
+	return p.Common().GetBodyMutation()
+}
+
+// ImmediateResponse returns the phase's immediate response, if any.
+func (p *PhaseResult) ImmediateResponse() *extprocv3.ImmediateResponse {
+	for _, resp := range p.Responses {
+		if ir := resp.GetImmediateResponse(); ir != nil {
+			return ir
+		}
+	}
+	return nil
+}
+
+// SetHeader returns the value the phase sets for the named header (the
+// last one, if set more than once), and whether it is set.
+func (p *PhaseResult) SetHeader(name string) (string, bool) {
+	value, ok := "", false
+	for _, h := range p.HeaderMutation().GetSetHeaders() {
+		if hv := h.GetHeader(); strings.EqualFold(hv.GetKey(), name) {
+			value, ok = hv.GetValue(), true
+			if len(hv.GetRawValue()) > 0 {
+				value = string(hv.GetRawValue())
+			}
+		}
+	}
+	return value, ok
+}
+
+// RemovesHeader reports whether the phase removes the named header.
+func (p *PhaseResult) RemovesHeader(name string) bool {
+	for _, r := range p.HeaderMutation().GetRemoveHeaders() {
+		if strings.EqualFold(r, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// body returns the body the phase replaces its chunk with (or streams in
+// full duplex mode), and whether it changes the chunk.
+func (p *PhaseResult) body() ([]byte, bool) {
+	if len(p.Responses) > 0 && streamedResponse(p.Responses[0]) != nil {
+		var body []byte
+		for _, resp := range p.Responses {
+			body = append(body, streamedResponse(resp).GetBody()...)
+		}
+		return body, true
+	}
+	switch m := p.BodyMutation().GetMutation().(type) {
+	case *extprocv3.BodyMutation_Body:
+		return m.Body, true
+	case *extprocv3.BodyMutation_ClearBody:
+		return nil, m.ClearBody
+	default:
+		return nil, false
+	}
+}
+
+// Result holds the phases of an exchange run through a processor.
+type Result struct {
+	t testing.TB
+
+	Phases []*PhaseResult
+
+	// Err is the stream's error, if it ended with one (e.g. a status
+	// error from a processor's phase error).
+	Err error
+
+	// Simulation holds the request and response after envoy applies the
+	// phases' responses.
+	Simulation *ep.Simulation
+}
+
+// Upstream returns the request as envoy sends it upstream.
+func (r *Result) Upstream() *ep.HTTPMessage {
+	return &r.Simulation.Request
+}
+
+// Downstream returns the response as envoy sends it to the client: the
+// local reply, if the exchange ended with one, or the (mutated) response.
+func (r *Result) Downstream() *ep.HTTPMessage {
+	if r.Simulation.LocalReply != nil {
+		return r.Simulation.LocalReply
+	}
+	return &r.Simulation.Response
+}
+
+// Phase returns the (first) result of a phase, or nil if the phase was
+// not sent.
+func (r *Result) Phase(phase int) *PhaseResult {
+	for _, pr := range r.Phases {
+		if pr.Phase == phase {
+			return pr
+		}
+	}
+	return nil
+}
+
+// ImmediateResponse returns the immediate response that ended the
+// exchange, if any.
+func (r *Result) ImmediateResponse() *extprocv3.ImmediateResponse {
+	for _, pr := range r.Phases {
+		if ir := pr.ImmediateResponse(); ir != nil {
+			return ir
+		}
+	}
+	return nil
+}
+
+// SetHeader returns the value the phase's results set for the named
+// header, and whether any set it.
+func (r *Result) SetHeader(phase int, name string) (string, bool) {
+	value, ok := "", false
+	for _, pr := range r.Phases {
+		if pr.Phase == phase {
+			if v, set := pr.SetHeader(name); set {
+				value, ok = v, true
+			}
+		}
+	}
+	return value, ok
+}
+
+// Body returns the body phase's mutations over all chunks, i.e. the body
+// sent on with each chunk replaced (or cleared) as its response says, and
+// whether any chunk was changed. A body replaced in the headers phase
+// (CONTINUE_AND_REPLACE) is returned for the body phase of its direction.
+func (r *Result) Body(phase int) ([]byte, bool) {
+	var body []byte
+	changed := false
+	for _, pr := range r.Phases {
+		if isHeadersPhase(pr.Phase) && direction(pr.Phase) == direction(phase) &&
+			pr.Common().GetStatus() == extprocv3.CommonResponse_CONTINUE_AND_REPLACE {
+			replaced, _ := pr.body()
+			return replaced, true
+		}
+		if pr.Phase != phase {
+			continue
+		}
+		if replaced, ok := pr.body(); ok {
+			body = append(body, replaced...)
+			changed = true
+		} else if len(pr.Responses) > 0 {
+			// full duplex chunks without responses are only sent on as
+			// streamed by the last chunk's responses
+			body = append(body, chunkOf(pr.Request)...)
+		}
+	}
+	return body, changed
+}
+
+func chunkOf(req *extprocv3.ProcessingRequest) []byte {
+	if b := req.GetRequestBody(); b != nil {
+		return b.GetBody()
+	}
+	return req.GetResponseBody().GetBody()
+}
+
+// AssertNoError checks that the stream ended without an error.
+func (r *Result) AssertNoError() {
+	r.t.Helper()
+	if r.Err != nil {
+		r.t.Errorf("stream error: %v", r.Err)
+	}
+}
+
+// AssertContinued checks that the exchange ended without an error or an
+// immediate response.
+func (r *Result) AssertContinued() {
+	r.t.Helper()
+	r.AssertNoError()
+	if ir := r.ImmediateResponse(); ir != nil {
+		r.t.Errorf("immediate response with status %d, want none", ir.GetStatus().GetCode())
+	}
+}
+
+// AssertImmediateResponse checks that the exchange ended with an
+// immediate response with status.
+func (r *Result) AssertImmediateResponse(status int) {
+	r.t.Helper()
+	ir := r.ImmediateResponse()
+	if ir == nil {
+		r.t.Errorf("no immediate response, want status %d", status)
+		return
+	}
+	if got := int(ir.GetStatus().GetCode()); got != status {
+		r.t.Errorf("immediate response with status %d, want %d", got, status)
+	}
+}
+
+// AssertHeader checks that the phase sets the named header to value.
+func (r *Result) AssertHeader(phase int, name, value string) {
+	r.t.Helper()
+	got, ok := r.SetHeader(phase, name)
+	if !ok {
+		r.t.Errorf("%s: header %q not set, want %q", ep.PhaseName(phase), name, value)
+		return
+	}
+	if got != value {
+		r.t.Errorf("%s: header %q set to %q, want %q", ep.PhaseName(phase), name, got, value)
+	}
+}
+
+// AssertNoHeader checks that the phase does not set the named header.
+func (r *Result) AssertNoHeader(phase int, name string) {
+	r.t.Helper()
+	if got, ok := r.SetHeader(phase, name); ok {
+		r.t.Errorf("%s: header %q set to %q, want not set", ep.PhaseName(phase), name, got)
+	}
+}
+
+// AssertHeaderRemoved checks that the phase removes the named header.
+func (r *Result) AssertHeaderRemoved(phase int, name string) {
+	r.t.Helper()
+	for _, pr := range r.Phases {
+		if pr.Phase == phase && pr.RemovesHeader(name) {
+			return
+		}
+	}
+	r.t.Errorf("%s: header %q not removed", ep.PhaseName(phase), name)
+}
+
+// AssertBody checks that the body phase changes the body to want (see
+// Body).
+func (r *Result) AssertBody(phase int, want string) {
+	r.t.Helper()
+	got, changed := r.Body(phase)
+	if !changed {
+		r.t.Errorf("%s: body not changed, want %q", ep.PhaseName(phase), want)
+		return
+	}
+	if !bytes.Equal(got, []byte(want)) {
+		r.t.Errorf("%s: body changed to %q, want %q", ep.PhaseName(phase), got, want)
+	}
+}
+
+// AssertBodyUnchanged checks that the body phase does not change the body.
+func (r *Result) AssertBodyUnchanged(phase int) {
+	r.t.Helper()
+	if got, changed := r.Body(phase); changed {
+		r.t.Errorf("%s: body changed to %q, want unchanged", ep.PhaseName(phase), got)
+	}
+}
+
+// AssertUpstreamHeader checks the values of the named header (or
+// pseudo-header) of the request sent upstream; no values checks that it
+// is absent.
+func (r *Result) AssertUpstreamHeader(name string, values ...string) {
+	r.t.Helper()
+	assertHeader(r.t, "upstream", r.Upstream().Headers, name, values)
+}
+
+// AssertDownstreamHeader checks the values of the named header (or
+// pseudo-header) of the response sent to the client; no values checks
+// that it is absent.
+func (r *Result) AssertDownstreamHeader(name string, values ...string) {
+	r.t.Helper()
+	assertHeader(r.t, "downstream", r.Downstream().Headers, name, values)
+}
+
+// AssertUpstreamBody checks the body of the request sent upstream.
+func (r *Result) AssertUpstreamBody(want string) {
+	r.t.Helper()
+	if got := r.Upstream().Body; string(got) != want {
+		r.t.Errorf("upstream body %q, want %q", got, want)
+	}
+}
+
+// AssertDownstreamBody checks the body of the response sent to the client.
+func (r *Result) AssertDownstreamBody(want string) {
+	r.t.Helper()
+	if got := r.Downstream().Body; string(got) != want {
+		r.t.Errorf("downstream body %q, want %q", got, want)
+	}
+}
+
+func assertHeader(t testing.TB, side string, headers ep.Headers, name string, want []string) {
+	t.Helper()
+	var got []string
+	if strings.HasPrefix(name, ":") {
+		if v := headers.Pseudo(name); v != "" {
+			got = []string{v}
+		}
+	} else {
+		got = headers.Values(name)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("%s header %q is %q, want %q", side, name, got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package extproctest
+
+import (
+	"fmt"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+// recordingTB records assertion failures instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func bodyPhase(chunk string, eos bool) *extprocv3.ProcessingRequest {
+	return &extprocv3.ProcessingRequest{
+		Request: &extprocv3.ProcessingRequest_RequestBody{
+			RequestBody: &extprocv3.HttpBody{Body: []byte(chunk), EndOfStream: eos},
+		},
+	}
+}
+
+func bodyResponse(common *extprocv3.CommonResponse) *extprocv3.ProcessingResponse {
+	return &extprocv3.ProcessingResponse{
+		Response: &extprocv3.ProcessingResponse_RequestBody{
+			RequestBody: &extprocv3.BodyResponse{Response: common},
+		},
+	}
+}
+
+func newResult(t testing.TB, phases ...*PhaseResult) *Result {
+	res := &Result{t: t, Phases: phases, Simulation: &ep.Simulation{}}
+	for _, pr := range phases {
+		res.Simulation.Apply(pr.Request, pr.Responses...)
+	}
+	return res
+}
+
+func TestResultBody(t *testing.T) {
+	replaced := &PhaseResult{
+		Phase:   ep.REQUEST_PHASE_REQUEST_BODY,
+		Request: bodyPhase("a", false),
+		Responses: []*extprocv3.ProcessingResponse{bodyResponse(&extprocv3.CommonResponse{
+			BodyMutation: &extprocv3.BodyMutation{Mutation: &extprocv3.BodyMutation_Body{Body: []byte("A")}},
+		})},
+	}
+	cleared := &PhaseResult{
+		Phase:   ep.REQUEST_PHASE_REQUEST_BODY,
+		Request: bodyPhase("b", false),
+		Responses: []*extprocv3.ProcessingResponse{bodyResponse(&extprocv3.CommonResponse{
+			BodyMutation: &extprocv3.BodyMutation{Mutation: &extprocv3.BodyMutation_ClearBody{ClearBody: true}},
+		})},
+	}
+	unchanged := &PhaseResult{
+		Phase:     ep.REQUEST_PHASE_REQUEST_BODY,
+		Request:   bodyPhase("c", true),
+		Responses: []*extprocv3.ProcessingResponse{bodyResponse(&extprocv3.CommonResponse{})},
+	}
+
+	res := newResult(t, replaced, cleared, unchanged)
+	body, changed := res.Body(ep.REQUEST_PHASE_REQUEST_BODY)
+	if string(body) != "Ac" || !changed {
+		t.Errorf("Body() = %q, %v, want \"Ac\", true", body, changed)
+	}
+	res.AssertUpstreamBody("Ac")
+
+	res = newResult(t, unchanged)
+	if body, changed := res.Body(ep.REQUEST_PHASE_REQUEST_BODY); changed {
+		t.Errorf("Body() = %q, true, want unchanged", body)
+	}
+	res.AssertBodyUnchanged(ep.REQUEST_PHASE_REQUEST_BODY)
+}
+
+func TestResultAssertionsFail(t *testing.T) {
+	headers := &PhaseResult{
+		Phase: ep.REQUEST_PHASE_REQUEST_HEADERS,
+		Request: &extprocv3.ProcessingRequest{
+			Request: &extprocv3.ProcessingRequest_RequestHeaders{RequestHeaders: &extprocv3.HttpHeaders{
+				Headers: &corev3.HeaderMap{Headers: []*corev3.HeaderValue{{Key: "x-a", RawValue: []byte("1")}}},
+			}},
+		},
+		Responses: []*extprocv3.ProcessingResponse{{
+			Response: &extprocv3.ProcessingResponse_ImmediateResponse{
+				ImmediateResponse: &extprocv3.ImmediateResponse{Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden}},
+			},
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		assert func(*Result)
+		fails  bool
+	}{
+		{"continued", func(r *Result) { r.AssertContinued() }, true},
+		{"immediate response", func(r *Result) { r.AssertImmediateResponse(403) }, false},
+		{"wrong immediate response", func(r *Result) { r.AssertImmediateResponse(401) }, true},
+		{"header not set", func(r *Result) { r.AssertHeader(ep.REQUEST_PHASE_REQUEST_HEADERS, "x-a", "1") }, true},
+		{"no header", func(r *Result) { r.AssertNoHeader(ep.REQUEST_PHASE_REQUEST_HEADERS, "x-a") }, false},
+		{"header not removed", func(r *Result) { r.AssertHeaderRemoved(ep.REQUEST_PHASE_REQUEST_HEADERS, "x-a") }, true},
+		{"upstream header", func(r *Result) { r.AssertUpstreamHeader("x-a", "1") }, false},
+		{"upstream header absent", func(r *Result) { r.AssertUpstreamHeader("x-a") }, true},
+		{"downstream status", func(r *Result) { r.AssertDownstreamHeader(":status", "403") }, false},
+		{"body not changed", func(r *Result) { r.AssertBody(ep.REQUEST_PHASE_REQUEST_BODY, "x") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recordingTB{TB: t}
+			tt.assert(newResult(rec, headers))
+			if failed := len(rec.errors) > 0; failed != tt.fails {
+				t.Errorf("assertion failed: %v (%q), want %v", failed, rec.errors, tt.fails)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package extproc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// IsGrpc reports whether the request is a gRPC request, from its
+// content-type (application/grpc, application/grpc+proto, ...).
+func (rc *RequestContext) IsGrpc() bool {
+	return strings.HasPrefix(strings.ToLower(rc.Headers.Get("content-type")), "application/grpc")
+}
+
+// CancelGrpcRequest responds to a gRPC client immediately with a gRPC
+// status: code and message, along with any details (e.g. errdetails
+// messages). As gRPC requires, the HTTP status is 200 and the status is
+// sent in the grpc-status, grpc-message and grpc-status-details-bin
+// headers of a trailers-only response. The response details (envoy's
+// %RESPONSE_CODE_DETAILS%) are "grpc_status_<code>" and the message.
+func (rc *RequestContext) CancelGrpcRequest(code codes.Code, message string, details ...proto.Message) error {
+	st := status.New(code, message)
+	if len(details) > 0 {
+		v1 := make([]protoadapt.MessageV1, 0, len(details))
+		for _, d := range details {
+			v1 = append(v1, protoadapt.MessageV1Of(d))
+		}
+		var err error
+		if st, err = st.WithDetails(v1...); err != nil {
+			return fmt.Errorf("cannot add gRPC status details: %w", err)
+		}
+	}
+
+	rc.Logger().Debug("cancelling gRPC request", "code", code.String())
+	rc.OverwriteHeader("content-type", HeaderValue{RawValue: []byte("application/grpc")})
+	rc.OverwriteHeader("grpc-status", HeaderValue{RawValue: []byte(strconv.Itoa(int(code)))})
+	if message != "" {
+		rc.OverwriteHeader("grpc-message", HeaderValue{RawValue: []byte(encodeGrpcMessage(message))})
+	}
+	if len(details) > 0 {
+		bin, err := proto.Marshal(st.Proto())
+		if err != nil {
+			return fmt.Errorf("cannot encode gRPC status details: %w", err)
+		}
+		rc.OverwriteHeader("grpc-status-details-bin", HeaderValue{RawValue: []byte(base64.RawStdEncoding.EncodeToString(bin))})
+	}
+
+	if err := rc.CancelRequest(http.StatusOK, nil, ""); err != nil {
+		return err
+	}
+	rc.response.immediateResponse.GrpcStatus = &extprocv3.GrpcStatus{Status: uint32(code)}
+	rc.response.immediateResponse.Details = fmt.Sprintf("grpc_status_%d", code)
+	if message != "" {
+		rc.response.immediateResponse.Details += ": " + message
+	}
+	return nil
+}
+
+// encodeGrpcMessage percent-encodes a grpc-message value, as specified
+// by the gRPC HTTP/2 protocol.
+func encodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
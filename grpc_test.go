@@ -0,0 +1,82 @@
+package extproc_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+func TestCancelGrpcRequest(t *testing.T) {
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "grpc",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			if !ctx.IsGrpc() {
+				return ctx.ContinueRequest()
+			}
+			return ctx.CancelGrpcRequest(codes.PermissionDenied, "not allowed: 100%",
+				&errdetails.ErrorInfo{Reason: "POLICY", Domain: "example.org"})
+		},
+	})
+	res := h.Request("POST", "/pkg.Service/Method").Header("content-type", "application/grpc+proto").Run()
+
+	res.AssertImmediateResponse(200)
+	res.AssertDownstreamHeader("content-type", "application/grpc")
+	res.AssertDownstreamHeader("grpc-status", "7")
+	res.AssertDownstreamHeader("grpc-message", "not allowed: 100%25")
+
+	ir := res.Phase(ep.REQUEST_PHASE_REQUEST_HEADERS).Responses[0].GetImmediateResponse()
+	if got := ir.GetGrpcStatus().GetStatus(); got != uint32(codes.PermissionDenied) {
+		t.Errorf("grpc status %d, want %d", got, codes.PermissionDenied)
+	}
+	if got, want := ir.GetDetails(), "grpc_status_7: not allowed: 100%"; got != want {
+		t.Errorf("details %q, want %q", got, want)
+	}
+
+	bin, err := base64.RawStdEncoding.DecodeString(res.Downstream().Headers.Get("grpc-status-details-bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sp spb.Status
+	if err := proto.Unmarshal(bin, &sp); err != nil {
+		t.Fatal(err)
+	}
+	st := status.FromProto(&sp)
+	if st.Code() != codes.PermissionDenied || len(st.Details()) != 1 {
+		t.Fatalf("status details %v, want one detail", st.Details())
+	}
+	if info, ok := st.Details()[0].(*errdetails.ErrorInfo); !ok || info.Reason != "POLICY" {
+		t.Errorf("detail %v, want the ErrorInfo", st.Details()[0])
+	}
+
+	// not gRPC: no status headers
+	res = h.Request("GET", "/").Run()
+	res.AssertContinued()
+}
+
+func TestCancelGrpcRequestWithoutMessage(t *testing.T) {
+	res := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "grpc",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			return ctx.CancelGrpcRequest(codes.Unavailable, "")
+		},
+	}).Request("POST", "/pkg.Service/Method").Header("content-type", "application/grpc").Run()
+
+	res.AssertImmediateResponse(200)
+	res.AssertDownstreamHeader("grpc-status", "14")
+	if res.Downstream().Headers.Has("grpc-message") || res.Downstream().Headers.Has("grpc-status-details-bin") {
+		t.Errorf("downstream headers %v, want no message or details", res.Downstream().Headers.Fields())
+	}
+	ir := res.Phase(ep.REQUEST_PHASE_REQUEST_HEADERS).Responses[0].GetImmediateResponse()
+	if ir.GetDetails() != "grpc_status_14" {
+		t.Errorf("details %q, want grpc_status_14", ir.GetDetails())
+	}
+}
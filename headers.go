@@ -1,31 +1,206 @@
 package extproc
 
 import (
-	"fmt"
+	"slices"
 	"strings"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 )
 
-type AllHeaders struct {
-	Headers    map[string][]string
-	RawHeaders map[string][]byte
+// HeaderField is a single header as received from envoy. Value holds the
+// header's value whether envoy sent it as value or raw_value.
+type HeaderField struct {
+	Name  string
+	Value string
 }
 
-func genHeaders(headerMap *corev3.HeaderMap) (headers AllHeaders, err error) {
-	headers = AllHeaders{map[string][]string{}, map[string][]byte{}}
+// Headers holds the headers (or trailers) of a phase in the order envoy
+// sent them, keeping repeated headers as separate fields. Lookups are case
+// insensitive. Pseudo-headers (":path", ":method", ...) are kept apart
+// from regular headers.
+type Headers struct {
+	fields []HeaderField
+	pseudo []HeaderField
+}
+
+// AllHeaders is the former name of Headers.
+//
+// Deprecated: use Headers.
+type AllHeaders = Headers
+
+// NewHeaders creates Headers from fields, in order; fields named with a
+// leading ':' are pseudo-headers.
+func NewHeaders(fields ...HeaderField) Headers {
+	var h Headers
+	for _, f := range fields {
+		h.add(f)
+	}
+	return h
+}
+
+func newHeaders(headerMap *corev3.HeaderMap) Headers {
+	var h Headers
+	for _, hv := range headerMap.GetHeaders() {
+		value := hv.Value
+		if len(hv.RawValue) > 0 {
+			value = string(hv.RawValue)
+		}
+		h.add(HeaderField{Name: hv.Key, Value: value})
+	}
+	return h
+}
+
+func (h *Headers) add(f HeaderField) {
+	if strings.HasPrefix(f.Name, ":") {
+		h.pseudo = append(h.pseudo, f)
+	} else {
+		h.fields = append(h.fields, f)
+	}
+}
+
+// Get returns the first value of the named header, or "" if absent.
+func (h Headers) Get(name string) string {
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
 
-	for _, h := range headerMap.Headers {
-		if len(h.Value) > 0 && len(h.RawValue) > 0 {
-			err = fmt.Errorf("only one of 'value' or 'raw_value' can be set")
-			return
+// Values returns all values of the named header, in order. Values are not
+// split on commas, so headers like set-cookie or date are kept intact.
+func (h Headers) Values(name string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, f.Value)
 		}
+	}
+	return values
+}
+
+// Has reports whether the named header is present.
+func (h Headers) Has(name string) bool {
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields returns the regular (not pseudo) headers, in order. The slice
+// must not be modified.
+func (h Headers) Fields() []HeaderField {
+	return h.fields
+}
 
-		if len(h.Value) > 0 {
-			headers.Headers[h.Key] = strings.Split(h.Value, ",")
-		} else {
-			headers.RawHeaders[h.Key] = h.RawValue
+// Len returns the number of regular headers, counting repeats.
+func (h Headers) Len() int {
+	return len(h.fields)
+}
+
+// Pseudo returns the value of a pseudo-header, named with or without the
+// leading ':' (e.g. ":path" or "path"), or "" if absent.
+func (h Headers) Pseudo(name string) string {
+	name = ":" + strings.TrimPrefix(name, ":")
+	for _, f := range h.pseudo {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
 		}
 	}
-	return
+	return ""
+}
+
+// PseudoFields returns the pseudo-headers, in order. The slice must not
+// be modified.
+func (h Headers) PseudoFields() []HeaderField {
+	return h.pseudo
+}
+
+// without returns the fields not named name, in a new slice so Headers
+// shared with processors are not changed.
+func without(fields []HeaderField, name string) []HeaderField {
+	var kept []HeaderField
+	for _, f := range fields {
+		if !strings.EqualFold(f.Name, name) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// remove removes all headers (or pseudo-headers) named name.
+func (h *Headers) remove(name string) {
+	if strings.HasPrefix(name, ":") {
+		h.pseudo = without(h.pseudo, name)
+	} else {
+		h.fields = without(h.fields, name)
+	}
+}
+
+// has reports whether a header or pseudo-header named name is present.
+func (h *Headers) has(name string) bool {
+	if strings.HasPrefix(name, ":") {
+		return h.Pseudo(name) != ""
+	}
+	return h.Has(name)
+}
+
+// headerOption returns the (lower case) name and value of a header to set.
+func headerOption(h *corev3.HeaderValueOption) (string, string) {
+	value := h.GetHeader().GetValue()
+	if len(h.GetHeader().GetRawValue()) > 0 {
+		value = string(h.GetHeader().GetRawValue())
+	}
+	return strings.ToLower(h.GetHeader().GetKey()), value
+}
+
+// set sets a header as envoy does, according to its append action.
+func (h *Headers) set(opt *corev3.HeaderValueOption) {
+	name, value := headerOption(opt)
+
+	// the deprecated append field takes precedence
+	action := opt.GetAppendAction()
+	if opt.GetAppend() != nil {
+		action = corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD
+		if opt.GetAppend().GetValue() {
+			action = corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD
+		}
+	}
+
+	field := HeaderField{Name: name, Value: value}
+	switch action {
+	case corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD:
+		h.add(field)
+	case corev3.HeaderValueOption_ADD_IF_ABSENT:
+		if !h.has(name) {
+			h.add(field)
+		}
+	case corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD:
+		h.remove(name)
+		h.add(field)
+	case corev3.HeaderValueOption_OVERWRITE_IF_EXISTS:
+		if h.has(name) {
+			h.remove(name)
+			h.add(field)
+		}
+	}
+}
+
+// mutated returns a copy of the headers with a header mutation applied as
+// envoy applies it (removals first), ignoring envoy's mutation rules.
+func (h Headers) mutated(hm *extprocv3.HeaderMutation) Headers {
+	m := Headers{fields: slices.Clone(h.fields), pseudo: slices.Clone(h.pseudo)}
+	for _, name := range hm.GetRemoveHeaders() {
+		if name = strings.ToLower(name); !strings.HasPrefix(name, ":") && name != "host" {
+			m.remove(name)
+		}
+	}
+	for _, opt := range hm.GetSetHeaders() {
+		m.set(opt)
+	}
+	return m
 }
@@ -0,0 +1,77 @@
+package extproc_test
+
+import (
+	"slices"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+func TestHeaders(t *testing.T) {
+	h := ep.NewHeaders(
+		ep.HeaderField{Name: ":method", Value: "GET"},
+		ep.HeaderField{Name: "Set-Cookie", Value: "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT"},
+		ep.HeaderField{Name: ":path", Value: "/things"},
+		ep.HeaderField{Name: "x-id", Value: "1"},
+		ep.HeaderField{Name: "set-cookie", Value: "b=2"},
+	)
+
+	if got := h.Get("SET-COOKIE"); got != "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT" {
+		t.Errorf("Get = %q, want the first value", got)
+	}
+	if got := h.Values("set-cookie"); !slices.Equal(got, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}) {
+		t.Errorf("Values = %q, want both cookies unsplit", got)
+	}
+	if !h.Has("X-Id") || h.Has("x-missing") || h.Get("x-missing") != "" {
+		t.Error("Has/Get wrong for present and missing headers")
+	}
+	if h.Has(":path") || h.Get(":method") != "" {
+		t.Error("pseudo-headers found among regular headers")
+	}
+	if h.Pseudo("path") != "/things" || h.Pseudo(":METHOD") != "GET" || h.Pseudo("authority") != "" {
+		t.Errorf("Pseudo wrong: %v", h.PseudoFields())
+	}
+
+	var names []string
+	for _, f := range h.Fields() {
+		names = append(names, f.Name)
+	}
+	if !slices.Equal(names, []string{"Set-Cookie", "x-id", "set-cookie"}) || h.Len() != 3 {
+		t.Errorf("fields %q (len %d), want regular headers in order", names, h.Len())
+	}
+	if len(h.PseudoFields()) != 2 || h.PseudoFields()[0].Name != ":method" {
+		t.Errorf("pseudo fields %v, want both in order", h.PseudoFields())
+	}
+}
+
+func TestHeadersFromEnvoy(t *testing.T) {
+	var got ep.Headers
+	stream := rawStream(t, &ep.ProcessorFuncs{
+		Name: "headers",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			got = headers
+			return ctx.ContinueRequest()
+		},
+	})
+	exchange(t, stream, &extprocv3.ProcessingRequest{
+		Request: &extprocv3.ProcessingRequest_RequestHeaders{RequestHeaders: &extprocv3.HttpHeaders{
+			Headers: &corev3.HeaderMap{Headers: []*corev3.HeaderValue{
+				{Key: ":path", RawValue: []byte("/raw")},
+				{Key: "x-value", Value: "plain"},
+				{Key: "x-raw", RawValue: []byte("raw")},
+				{Key: "x-value", RawValue: []byte("again")},
+			}},
+			EndOfStream: true,
+		}},
+	})
+
+	if got.Pseudo("path") != "/raw" || got.Get("x-raw") != "raw" {
+		t.Errorf("raw values not read: %v %v", got.PseudoFields(), got.Fields())
+	}
+	if values := got.Values("x-value"); !slices.Equal(values, []string{"plain", "again"}) {
+		t.Errorf("x-value values %q, want value then raw_value", values)
+	}
+}
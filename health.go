@@ -2,7 +2,9 @@ package extproc
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -10,13 +12,205 @@ import (
 	pb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-type HealthServer struct{}
+// how often Watch re-runs a HealthChecker when one is defined
+const kDefaultWatchInterval = 5 * time.Second
+
+// HealthChecker can be implemented by a RequestProcessor to take part in
+// gRPC health checks, e.g. by checking a backing cache or policy store.
+// A non-nil error reports NOT_SERVING.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// HealthServer implements the gRPC health service, reporting a status per
+// service name ("" being the overall server). Statuses are combined with
+// an optional HealthChecker: a SERVING service is reported NOT_SERVING
+// while the checker fails. The zero value reports "" as SERVING.
+type HealthServer struct {
+	Logger  *slog.Logger
+	Checker HealthChecker
+
+	// WatchInterval is how often Watch streams re-run the Checker
+	WatchInterval time.Duration
+
+	mu       sync.Mutex
+	statuses map[string]pb.HealthCheckResponse_ServingStatus
+	watchers map[string]map[chan pb.HealthCheckResponse_ServingStatus]struct{}
+	shutdown bool
+	done     chan struct{}
+}
+
+// NewHealthServer creates a HealthServer reporting NOT_SERVING for the
+// given services (and "") until SetServingStatus or Resume is called.
+func NewHealthServer(checker HealthChecker, logger *slog.Logger, services ...string) *HealthServer {
+	s := &HealthServer{Logger: logger, Checker: checker}
+	s.init()
+	s.statuses[""] = pb.HealthCheckResponse_NOT_SERVING
+	for _, service := range services {
+		s.statuses[service] = pb.HealthCheckResponse_NOT_SERVING
+	}
+	return s
+}
+
+// init must be called with mu held (or before the server is shared)
+func (s *HealthServer) init() {
+	if s.statuses == nil {
+		s.statuses = map[string]pb.HealthCheckResponse_ServingStatus{"": pb.HealthCheckResponse_SERVING}
+	}
+	if s.watchers == nil {
+		s.watchers = make(map[string]map[chan pb.HealthCheckResponse_ServingStatus]struct{})
+	}
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+}
+
+// SetServingStatus sets the status of service and notifies watchers.
+// Updates are ignored after Shutdown.
+func (s *HealthServer) SetServingStatus(service string, st pb.HealthCheckResponse_ServingStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.shutdown {
+		return
+	}
+	s.setLocked(service, st)
+}
+
+func (s *HealthServer) setLocked(service string, st pb.HealthCheckResponse_ServingStatus) {
+	if prev, exists := s.statuses[service]; exists && prev == st {
+		return
+	}
+	s.statuses[service] = st
+	if s.Logger != nil {
+		s.Logger.Info("health status changed", "service", service, "status", st.String())
+	}
+	for ch := range s.watchers[service] {
+		// drop stale updates; watchers only need the latest status
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- st
+	}
+}
+
+// Resume sets all known services to SERVING.
+func (s *HealthServer) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.shutdown {
+		return
+	}
+	for service := range s.statuses {
+		s.setLocked(service, pb.HealthCheckResponse_SERVING)
+	}
+}
+
+// Shutdown sets all services to NOT_SERVING, ignores further updates, and
+// ends Watch streams (so they do not hold up a graceful stop).
+func (s *HealthServer) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.shutdown {
+		return
+	}
+	for service := range s.statuses {
+		s.setLocked(service, pb.HealthCheckResponse_NOT_SERVING)
+	}
+	s.shutdown = true
+	close(s.done)
+}
+
+// status combines the stored status of service with the checker.
+func (s *HealthServer) status(ctx context.Context, service string) (pb.HealthCheckResponse_ServingStatus, bool) {
+	s.mu.Lock()
+	s.init()
+	st, exists := s.statuses[service]
+	s.mu.Unlock()
+	if !exists {
+		return pb.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	if st == pb.HealthCheckResponse_SERVING && s.Checker != nil {
+		if err := s.Checker.HealthCheck(ctx); err != nil {
+			if s.Logger != nil {
+				s.Logger.Warn("health check failed", "service", service, "error", err)
+			}
+			return pb.HealthCheckResponse_NOT_SERVING, true
+		}
+	}
+	return st, true
+}
 
 func (s *HealthServer) Check(ctx context.Context, req *pb.HealthCheckRequest) (*pb.HealthCheckResponse, error) {
-	log.Printf("Handling grpc Check request + %s", req.String())
-	return &pb.HealthCheckResponse{Status: pb.HealthCheckResponse_SERVING}, nil
+	if s.Logger != nil {
+		s.Logger.Debug("handling grpc health check", "service", req.GetService())
+	}
+	st, exists := s.status(ctx, req.GetService())
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &pb.HealthCheckResponse{Status: st}, nil
 }
 
 func (s *HealthServer) Watch(req *pb.HealthCheckRequest, srv pb.Health_WatchServer) error {
-	return status.Error(codes.Unimplemented, "Watch is not implemented")
+	service := req.GetService()
+	ctx := srv.Context()
+
+	updates := make(chan pb.HealthCheckResponse_ServingStatus, 1)
+	s.mu.Lock()
+	s.init()
+	if s.watchers[service] == nil {
+		s.watchers[service] = make(map[chan pb.HealthCheckResponse_ServingStatus]struct{})
+	}
+	s.watchers[service][updates] = struct{}{}
+	done := s.done
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers[service], updates)
+		s.mu.Unlock()
+	}()
+
+	interval := s.WatchInterval
+	if interval <= 0 {
+		interval = kDefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := pb.HealthCheckResponse_ServingStatus(-1)
+	send := func() error {
+		st, _ := s.status(ctx, service)
+		if st == last {
+			return nil
+		}
+		last = st
+		return srv.Send(&pb.HealthCheckResponse{Status: st})
+	}
+
+	if err := send(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-done:
+			// send the final (NOT_SERVING) status before ending the stream
+			_ = send()
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case <-updates:
+		case <-ticker.C:
+			if s.Checker == nil {
+				continue
+			}
+		}
+		if err := send(); err != nil {
+			return err
+		}
+	}
 }
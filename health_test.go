@@ -0,0 +1,117 @@
+package extproc_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+type checkerFunc func(ctx context.Context) error
+
+func (f checkerFunc) HealthCheck(ctx context.Context) error { return f(ctx) }
+
+// healthClient serves hs in-process for the duration of the test.
+func healthClient(t *testing.T, hs *ep.HealthServer) pb.HealthClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 16)
+	srv := grpc.NewServer()
+	pb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///health",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+	return pb.NewHealthClient(conn)
+}
+
+func TestHealthCheck(t *testing.T) {
+	var failing error
+	hs := ep.NewHealthServer(checkerFunc(func(context.Context) error { return failing }), nil, "extproc")
+	client := healthClient(t, hs)
+	ctx := context.Background()
+
+	check := func(service string, want pb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := client.Check(ctx, &pb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Errorf("%q is %v, want %v", service, resp.Status, want)
+		}
+	}
+
+	check("extproc", pb.HealthCheckResponse_NOT_SERVING)
+	hs.Resume()
+	check("", pb.HealthCheckResponse_SERVING)
+	check("extproc", pb.HealthCheckResponse_SERVING)
+
+	failing = errors.New("cache down")
+	check("extproc", pb.HealthCheckResponse_NOT_SERVING)
+
+	if _, err := client.Check(ctx, &pb.HealthCheckRequest{Service: "other"}); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown service error %v, want NotFound", err)
+	}
+}
+
+func TestHealthWatch(t *testing.T) {
+	hs := ep.NewHealthServer(nil, nil, "extproc")
+	client := healthClient(t, hs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &pb.HealthCheckRequest{Service: "extproc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func(want pb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Errorf("watched %v, want %v", resp.Status, want)
+		}
+	}
+
+	next(pb.HealthCheckResponse_NOT_SERVING)
+	hs.SetServingStatus("extproc", pb.HealthCheckResponse_SERVING)
+	next(pb.HealthCheckResponse_SERVING)
+	hs.Shutdown()
+	next(pb.HealthCheckResponse_NOT_SERVING)
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("watch ended with %v, want Unavailable", err)
+	}
+
+	// updates after shutdown are ignored
+	hs.SetServingStatus("extproc", pb.HealthCheckResponse_SERVING)
+	resp, err := client.Check(ctx, &pb.HealthCheckRequest{Service: "extproc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != pb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status %v after shutdown, want NOT_SERVING", resp.Status)
+	}
+}
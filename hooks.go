@@ -0,0 +1,94 @@
+package extproc
+
+// Optional lifecycle hooks a RequestProcessor can implement, in addition
+// to the phase methods. The server detects them with type assertions.
+
+// StreamStarter is called once per request stream, before the first
+// phase handler (and after the RequestContext is initialized from the
+// request headers, when they are sent). An error is handled like a phase
+// handler error for the first phase.
+type StreamStarter interface {
+	OnStreamStart(ctx *RequestContext) error
+}
+
+// StreamFinisher is called once when a request stream ends, whether envoy
+// closed it normally (err is nil), it was cancelled, or processing failed,
+// with the final RequestContext. This is the place to release per-request
+// resources, since envoy may never send later phases (e.g. when the
+// upstream does not respond). Only called if the stream had started.
+type StreamFinisher interface {
+	OnStreamEnd(ctx *RequestContext, err error)
+}
+
+// PhasePreprocessor is called before every phase handler, with the
+// phase (a REQUEST_PHASE_* constant). An error skips the phase handler
+// and is handled like a phase handler error.
+type PhasePreprocessor interface {
+	PreprocessPhase(ctx *RequestContext, phase int) error
+}
+
+// PhasePostprocessor is called after every phase handler that does not
+// return an error. An error is handled like a phase handler error.
+type PhasePostprocessor interface {
+	PostprocessPhase(ctx *RequestContext, phase int) error
+}
+
+// ChainedProcessor forwards lifecycle hooks to the chained processors
+// that implement them, in the same order as the phases.
+
+func (c *ChainedProcessor) OnStreamStart(ctx *RequestContext) error {
+	for _, p := range c.processors {
+		if starter, ok := p.(StreamStarter); ok {
+			if err := starter.OnStreamStart(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OnStreamEnd calls every finisher, in reverse order, even after the
+// chain was cancelled so all processors can release their resources.
+func (c *ChainedProcessor) OnStreamEnd(ctx *RequestContext, err error) {
+	for i := len(c.processors) - 1; i >= 0; i-- {
+		if finisher, ok := c.processors[i].(StreamFinisher); ok {
+			finisher.OnStreamEnd(ctx, err)
+		}
+	}
+}
+
+func (c *ChainedProcessor) PreprocessPhase(ctx *RequestContext, phase int) error {
+	return c.inPhaseOrder(phase, func(p RequestProcessor) error {
+		if pre, ok := p.(PhasePreprocessor); ok {
+			return pre.PreprocessPhase(ctx, phase)
+		}
+		return nil
+	})
+}
+
+func (c *ChainedProcessor) PostprocessPhase(ctx *RequestContext, phase int) error {
+	return c.inPhaseOrder(phase, func(p RequestProcessor) error {
+		if post, ok := p.(PhasePostprocessor); ok {
+			return post.PostprocessPhase(ctx, phase)
+		}
+		return nil
+	})
+}
+
+func (c *ChainedProcessor) inPhaseOrder(phase int, call func(RequestProcessor) error) error {
+	switch phase {
+	case REQUEST_PHASE_RESPONSE_HEADERS, REQUEST_PHASE_RESPONSE_BODY, REQUEST_PHASE_RESPONSE_TRAILERS:
+		for i := len(c.processors) - 1; i >= 0; i-- {
+			if err := call(c.processors[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, p := range c.processors {
+			if err := call(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
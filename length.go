@@ -0,0 +1,102 @@
+package extproc
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+var errDeclarePhase = errors.New("body mutations can only be declared in headers phases")
+
+// bodySize tracks one direction's body size across its body phases, as
+// received from envoy and as sent on after any mutations.
+type bodySize struct {
+	original  int64
+	rewritten int64
+	declared  bool // content-length removed in the headers phase
+}
+
+func (rc *RequestContext) bodySize(phase int) *bodySize {
+	switch phase {
+	case REQUEST_PHASE_RESPONSE_HEADERS, REQUEST_PHASE_RESPONSE_BODY, REQUEST_PHASE_RESPONSE_TRAILERS:
+		return &rc.responseSize
+	default:
+		return &rc.requestSize
+	}
+}
+
+// WillMutateBody declares, in a headers phase, that the body that follows
+// (request or response) may change size. content-length is removed from
+// the headers so envoy sends the body with chunked transfer encoding
+// (HTTP/1.1) or without a declared length (HTTP/2), since the final size
+// is not known until the end of the body. Declare this whenever a body
+// may be replaced in a STREAMED body mode, where envoy does not accept
+// header mutations in body phases.
+func (rc *RequestContext) WillMutateBody() error {
+	switch rc.phase {
+	case REQUEST_PHASE_REQUEST_HEADERS, REQUEST_PHASE_RESPONSE_HEADERS:
+	default:
+		return errDeclarePhase
+	}
+	rc.bodySize(rc.phase).declared = true
+	return rc.RemoveHeader("content-length")
+}
+
+// BodySizes returns the size of the body (request or response, depending
+// on the phase) received from envoy in completed body phases, and its size
+// after mutations.
+func (rc *RequestContext) BodySizes(phase int) (original, rewritten int64) {
+	s := rc.bodySize(phase)
+	return s.original, s.rewritten
+}
+
+// setBodyLength updates content-length for a body chunk replaced with
+// size bytes. That is only possible when the chunk is the whole body
+// (e.g. in BUFFERED body mode); otherwise the length must be removed in
+// the headers phase with WillMutateBody.
+func (rc *RequestContext) setBodyLength(size int) {
+	switch rc.phase {
+	case REQUEST_PHASE_REQUEST_BODY, REQUEST_PHASE_RESPONSE_BODY:
+	default:
+		return
+	}
+	if rc.wholeBodyChunk() {
+		// replace rather than repeat the length set by earlier replacements
+		hm := rc.response.headerMutation
+		hm.SetHeaders = slices.DeleteFunc(hm.SetHeaders, func(h *corev3.HeaderValueOption) bool {
+			return strings.EqualFold(h.GetHeader().GetKey(), kContentLength)
+		})
+		rc.OverwriteHeader(kContentLength, HeaderValue{RawValue: []byte(strconv.Itoa(size))})
+	}
+}
+
+// wholeBodyChunk reports whether the body phase's chunk is the whole body,
+// i.e. the body was received in a single chunk.
+func (rc *RequestContext) wholeBodyChunk() bool {
+	return rc.EndOfStream && rc.bodySize(rc.phase).original == 0
+}
+
+// trackBodySize accounts for the body phase's received and sent chunks,
+// warning at the end of a resized body if content-length could not be
+// updated and was not removed.
+func (rc *RequestContext) trackBodySize() {
+	s := rc.bodySize(rc.phase)
+	whole := rc.EndOfStream && s.original == 0
+
+	s.original += int64(len(rc.chunk))
+	if chunks, streamed := rc.streamedChunks(rc.phase); streamed {
+		for _, chunk := range chunks {
+			s.rewritten += int64(len(chunk.Body))
+		}
+	} else {
+		s.rewritten += int64(len(rc.mutatedBody(rc.chunk)))
+	}
+
+	if rc.EndOfStream && !whole && !s.declared && s.original != s.rewritten {
+		rc.Logger().Warn("body resized across chunks without WillMutateBody, content-length may be wrong",
+			"phase", PhaseName(rc.phase), "original", s.original, "rewritten", s.rewritten)
+	}
+}
@@ -0,0 +1,59 @@
+package extproc_test
+
+import (
+	"testing"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+func TestContentLengthUpdatedForWholeBody(t *testing.T) {
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "length",
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			if err := ctx.ReplaceBodyChunk([]byte("longer body")); err != nil {
+				return err
+			}
+			// a second replacement must not repeat the length
+			return ctx.ReplaceBodyChunk([]byte("longest body"))
+		},
+	})
+	res := h.Request("POST", "/").Header("content-length", "4").Body("body").Run()
+
+	res.AssertContinued()
+	res.AssertUpstreamBody("longest body")
+	res.AssertUpstreamHeader("content-length", "12")
+}
+
+func TestContentLengthRemovedForStreamedBody(t *testing.T) {
+	var original, rewritten int64
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "length",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			if err := ctx.WillMutateBody(); err != nil {
+				return err
+			}
+			return ctx.ContinueRequest()
+		},
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			if err := ctx.WillMutateBody(); err == nil {
+				t.Error("WillMutateBody allowed in a body phase")
+			}
+			return ctx.ReplaceBodyChunk(append(body, body...))
+		},
+		RequestTrailers: func(ctx *ep.RequestContext, trailers ep.Headers) error {
+			original, rewritten = ctx.BodySizes(ep.REQUEST_PHASE_REQUEST_BODY)
+			return ctx.ContinueRequest()
+		},
+	})
+	res := h.Request("POST", "/").Header("content-length", "4").Body("ab", "cd").Trailer("t", "1").Run()
+
+	res.AssertContinued()
+	res.AssertHeaderRemoved(ep.REQUEST_PHASE_REQUEST_HEADERS, "content-length")
+	res.AssertNoHeader(ep.REQUEST_PHASE_REQUEST_BODY, "content-length")
+	res.AssertUpstreamHeader("content-length")
+	res.AssertUpstreamBody("ababcdcd")
+	if original != 4 || rewritten != 8 {
+		t.Errorf("BodySizes() = %d, %d, want 4, 8", original, rewritten)
+	}
+}
@@ -0,0 +1,87 @@
+package extproc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// WithSocketPermissions sets the file mode of Unix domain sockets created
+// for "unix://" addresses (by default the mode is left as created, i.e.
+// subject to the process umask). envoy must be able to write to the
+// socket to connect.
+func WithSocketPermissions(mode fs.FileMode) Option {
+	return func(c *serverConfig) {
+		c.socketMode = mode
+	}
+}
+
+// Listen creates a listener for addr, which is either a TCP address like
+// "127.0.0.1:50051" or ":50051", or a Unix domain socket address like
+// "unix:///var/run/extproc.sock" (matching envoy's pipe address). A stale
+// socket file left behind by a previous process is removed, but Listen
+// fails if another process is still accepting connections on it. Socket
+// files are removed when the listener is closed.
+func Listen(addr string, mode fs.FileMode) (net.Listener, error) {
+	path, isUnix := unixSocketPath(addr)
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("invalid unix socket address %q", addr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	lis.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			lis.Close()
+			return nil, fmt.Errorf("cannot set socket permissions on %s: %w", path, err)
+		}
+	}
+	return lis, nil
+}
+
+// unixSocketPath parses "unix:///abs/path" and "unix:rel/path" addresses,
+// as understood by gRPC clients.
+func unixSocketPath(addr string) (string, bool) {
+	if path, found := strings.CutPrefix(addr, "unix://"); found {
+		return path, true
+	}
+	if path, found := strings.CutPrefix(addr, "unix:"); found {
+		return path, true
+	}
+	return "", false
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("cannot listen on %s: socket is in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,128 @@
+package extproc_test
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/health/grpc_health_v1"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+func TestListenTCP(t *testing.T) {
+	lis, err := ep.Listen("127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if lis.Addr().Network() != "tcp" {
+		t.Errorf("network %s, want tcp", lis.Addr().Network())
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extproc.sock")
+	lis, err := ep.Listen("unix://"+path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&fs.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode %v, want a socket with 0600", info.Mode())
+	}
+
+	lis.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file left after close: %v", err)
+	}
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extproc.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	lis, err := ep.Listen("unix:"+path, 0)
+	if err != nil {
+		t.Fatalf("listening over a stale socket: %v", err)
+	}
+	lis.Close()
+}
+
+func TestListenErrors(t *testing.T) {
+	dir := t.TempDir()
+	inUse := filepath.Join(dir, "in-use.sock")
+	lis, err := net.Listen("unix", inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, want := range map[string]string{
+		"unix://":         "invalid unix socket address",
+		"unix://" + inUse: "socket is in use",
+		"unix://" + file:  "not a socket",
+	} {
+		if _, err := ep.Listen(addr, 0); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Listen(%q) error %v, want %q", addr, err, want)
+		}
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extproc.sock")
+	server, err := ep.NewServer(&ep.ProcessorFuncs{Name: "unix"}, ep.WithAddress("unix://"+path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, nil) }()
+	defer func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("serve error %v", err)
+		}
+	}()
+
+	conn, err := grpc.NewClient("unix://"+path, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cctx, ccancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ccancel()
+	// the health service resumes just after the server starts
+	for {
+		resp, err := pb.NewHealthClient(conn).Check(cctx, &pb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status == pb.HealthCheckResponse_SERVING {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,82 @@
+package extproc_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// logBuffer collects JSON log records written by the server's goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the logged records with message msg.
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid log record %q: %v", scanner.Text(), err)
+		}
+		if record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestRequestLogger(t *testing.T) {
+	logs := &logBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name:    "logging",
+		Options: &ep.ProcessingOptions{Logger: logger, LogStream: true},
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			ctx.Logger().Info("checked", "user", "alice")
+			ctx.Logger().Debug("not logged at info")
+			return ctx.ContinueRequest()
+		},
+	})
+	h.Request("GET", "/things?page=2").Header("x-request-id", "req-1").Run().AssertContinued()
+
+	records := logs.records(t, "checked")
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	for k, want := range map[string]any{
+		"level":      "INFO",
+		"processor":  "logging",
+		"request_id": "req-1",
+		"method":     "GET",
+		"path":       "/things",
+		"user":       "alice",
+	} {
+		if got := records[0][k]; got != want {
+			t.Errorf("record %s = %v, want %v", k, got, want)
+		}
+	}
+	if got := logs.records(t, "not logged at info"); len(got) != 0 {
+		t.Errorf("debug record logged at info level: %v", got)
+	}
+	if got := logs.records(t, "starting request stream"); len(got) != 1 || got[0]["processor"] != "logging" {
+		t.Errorf("stream start records %v, want one with the processor", got)
+	}
+}
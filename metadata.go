@@ -0,0 +1,82 @@
+package extproc
+
+import (
+	"fmt"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// SetDynamicMetadata emits dynamic metadata under namespace with the
+// current phase response, for downstream filters (RBAC, access logs, Lua)
+// to consume. Values must be convertible with structpb.NewValue (nil,
+// bools, numbers, strings, []byte, []any and map[string]any). Like header
+// mutations, metadata is sent with the current phase only.
+//
+// Note the ext_proc filter only applies namespaces it is configured to
+// accept (see metadata_options.receiving_namespaces in the filter config).
+func (rc *RequestContext) SetDynamicMetadata(namespace, key string, value any) error {
+	val, err := structpb.NewValue(value)
+	if err != nil {
+		return fmt.Errorf("invalid dynamic metadata value for %s.%s: %w", namespace, key, err)
+	}
+
+	if rc.response.dynamicMetadata == nil {
+		rc.response.dynamicMetadata = &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	}
+	fields := rc.response.dynamicMetadata.Fields
+
+	ns, exists := fields[namespace]
+	if !exists || ns.GetStructValue() == nil {
+		ns = structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{}})
+		fields[namespace] = ns
+	}
+	ns.GetStructValue().Fields[key] = val
+	return nil
+}
+
+// MetadataContext returns a copy of the metadata envoy forwarded with the
+// latest processing request (see metadata_options.forwarding_namespaces in
+// the filter config), or nil if none was sent.
+func (rc *RequestContext) MetadataContext() *corev3.Metadata {
+	if rc.metadata == nil {
+		return nil
+	}
+	return proto.Clone(rc.metadata).(*corev3.Metadata)
+}
+
+// FilterMetadata returns the decoded (untyped) filter metadata forwarded by
+// envoy under namespace, or nil if there is none.
+func (rc *RequestContext) FilterMetadata(namespace string) map[string]any {
+	st, exists := rc.metadata.GetFilterMetadata()[namespace]
+	if !exists {
+		return nil
+	}
+	return st.AsMap()
+}
+
+// FilterMetadataValue returns a single decoded filter metadata value.
+func (rc *RequestContext) FilterMetadataValue(namespace, key string) (any, bool) {
+	st, exists := rc.metadata.GetFilterMetadata()[namespace]
+	if !exists {
+		return nil, false
+	}
+	val, exists := st.GetFields()[key]
+	if !exists {
+		return nil, false
+	}
+	return val.AsInterface(), true
+}
+
+// TypedFilterMetadata returns a copy of the typed filter metadata
+// forwarded by envoy under namespace, or nil if there is none. Use
+// anypb.UnmarshalTo to decode it into the expected message.
+func (rc *RequestContext) TypedFilterMetadata(namespace string) *anypb.Any {
+	val, exists := rc.metadata.GetTypedFilterMetadata()[namespace]
+	if !exists {
+		return nil
+	}
+	return proto.Clone(val).(*anypb.Any)
+}
@@ -0,0 +1,73 @@
+package extproc_test
+
+import (
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+func TestDynamicMetadata(t *testing.T) {
+	var tenant any
+	var setErr error
+	stream := rawStream(t, &ep.ProcessorFuncs{
+		Name: "metadata",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			tenant, _ = ctx.FilterMetadataValue("envoy.filters.http.jwt_authn", "tenant")
+			ctx.SetDynamicMetadata("extproc", "decision", "allow")
+			ctx.SetDynamicMetadata("extproc", "score", 0.5)
+			setErr = ctx.SetDynamicMetadata("extproc", "invalid", make(chan int))
+			return ctx.ContinueRequest()
+		},
+	})
+
+	forwarded, _ := structpb.NewStruct(map[string]any{"tenant": "acme"})
+	resp := exchange(t, stream, &extprocv3.ProcessingRequest{
+		Request: &extprocv3.ProcessingRequest_RequestHeaders{RequestHeaders: &extprocv3.HttpHeaders{
+			Headers:     &corev3.HeaderMap{Headers: []*corev3.HeaderValue{{Key: ":method", RawValue: []byte("GET")}}},
+			EndOfStream: true,
+		}},
+		MetadataContext: &corev3.Metadata{
+			FilterMetadata: map[string]*structpb.Struct{"envoy.filters.http.jwt_authn": forwarded},
+		},
+	})
+
+	if tenant != "acme" {
+		t.Errorf("forwarded tenant %v, want acme", tenant)
+	}
+	if setErr == nil {
+		t.Error("SetDynamicMetadata accepted an invalid value")
+	}
+	ns := resp.GetDynamicMetadata().GetFields()["extproc"].GetStructValue().AsMap()
+	if ns["decision"] != "allow" || ns["score"] != 0.5 {
+		t.Errorf("dynamic metadata %v, want decision and score", ns)
+	}
+	if _, ok := ns["invalid"]; ok {
+		t.Error("invalid value emitted")
+	}
+}
+
+func TestDynamicMetadataIsPerPhase(t *testing.T) {
+	stream := rawStream(t, &ep.ProcessorFuncs{
+		Name: "metadata",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			ctx.SetDynamicMetadata("extproc", "phase", "request_headers")
+			return ctx.ContinueRequest()
+		},
+	})
+
+	exchange(t, stream, &extprocv3.ProcessingRequest{
+		Request: &extprocv3.ProcessingRequest_RequestHeaders{RequestHeaders: &extprocv3.HttpHeaders{
+			Headers: &corev3.HeaderMap{Headers: []*corev3.HeaderValue{{Key: ":method", RawValue: []byte("POST")}}},
+		}},
+	})
+	resp := exchange(t, stream, &extprocv3.ProcessingRequest{
+		Request: &extprocv3.ProcessingRequest_RequestBody{RequestBody: &extprocv3.HttpBody{Body: []byte("x"), EndOfStream: true}},
+	})
+	if md := resp.GetDynamicMetadata(); md != nil {
+		t.Errorf("request body response has metadata %v", md)
+	}
+}
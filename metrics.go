@@ -0,0 +1,159 @@
+package extproc
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsRecorder receives measurements from the ExtProc server. All
+// methods are labeled with the processor name from GetName() and phases
+// are REQUEST_PHASE_* constants (see PhaseName).
+type MetricsRecorder interface {
+	// StreamStarted is called for each new gRPC stream (i.e. request)
+	StreamStarted(processor string)
+	// StreamFinished is called when a stream ends, with the total time
+	// spent in processors for the request
+	StreamFinished(processor string, duration time.Duration)
+	// PhaseProcessed is called for each processed phase with the time
+	// spent in the processor
+	PhaseProcessed(processor string, phase int, duration time.Duration)
+	// ImmediateResponse is called when a phase responds immediately
+	ImmediateResponse(processor string, phase int, status int32)
+	// HandlerError is called when a phase handler returns an error
+	HandlerError(processor string, phase int)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) StreamStarted(string)                      {}
+func (noopMetrics) StreamFinished(string, time.Duration)      {}
+func (noopMetrics) PhaseProcessed(string, int, time.Duration) {}
+func (noopMetrics) ImmediateResponse(string, int, int32)      {}
+func (noopMetrics) HandlerError(string, int)                  {}
+
+// PrometheusMetrics is a MetricsRecorder exporting prometheus metrics:
+//
+//	extproc_streams_total{processor}
+//	extproc_stream_duration_seconds{processor}
+//	extproc_phases_total{processor,phase}
+//	extproc_phase_duration_seconds{processor,phase}
+//	extproc_immediate_responses_total{processor,phase,status}
+//	extproc_handler_errors_total{processor,phase}
+type PrometheusMetrics struct {
+	streams            *prometheus.CounterVec
+	streamDurations    *prometheus.HistogramVec
+	phases             *prometheus.CounterVec
+	phaseDurations     *prometheus.HistogramVec
+	immediateResponses *prometheus.CounterVec
+	handlerErrors      *prometheus.CounterVec
+	gatherer           prometheus.Gatherer
+}
+
+// NewPrometheusMetrics creates and registers the SDK's prometheus
+// metrics with reg (prometheus.DefaultRegisterer if nil). Registering
+// more than once with the same registerer reuses the existing metrics.
+// When reg can also be gathered (e.g. a *prometheus.Registry), it is the
+// registry served by WithMetricsListener.
+func NewPrometheusMetrics(reg prometheus.Registerer) (*PrometheusMetrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	gatherer, _ := reg.(prometheus.Gatherer)
+
+	// ExtProc processing times are typically well under a millisecond
+	buckets := prometheus.ExponentialBuckets(0.00001, 4, 10)
+
+	m := &PrometheusMetrics{
+		streams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "extproc_streams_total",
+			Help: "Number of ExtProc request streams.",
+		}, []string{"processor"}),
+		streamDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "extproc_stream_duration_seconds",
+			Help:    "Time spent in processors per request stream.",
+			Buckets: buckets,
+		}, []string{"processor"}),
+		phases: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "extproc_phases_total",
+			Help: "Number of processed request phases.",
+		}, []string{"processor", "phase"}),
+		phaseDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "extproc_phase_duration_seconds",
+			Help:    "Time spent in processors per request phase.",
+			Buckets: buckets,
+		}, []string{"processor", "phase"}),
+		immediateResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "extproc_immediate_responses_total",
+			Help: "Number of immediate responses sent, by HTTP status.",
+		}, []string{"processor", "phase", "status"}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "extproc_handler_errors_total",
+			Help: "Number of errors returned by phase handlers.",
+		}, []string{"processor", "phase"}),
+		gatherer: gatherer,
+	}
+
+	var err error
+	if m.streams, err = register(reg, m.streams); err != nil {
+		return nil, err
+	}
+	if m.streamDurations, err = register(reg, m.streamDurations); err != nil {
+		return nil, err
+	}
+	if m.phases, err = register(reg, m.phases); err != nil {
+		return nil, err
+	}
+	if m.phaseDurations, err = register(reg, m.phaseDurations); err != nil {
+		return nil, err
+	}
+	if m.immediateResponses, err = register(reg, m.immediateResponses); err != nil {
+		return nil, err
+	}
+	if m.handlerErrors, err = register(reg, m.handlerErrors); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
+
+// Gatherer returns the registry the metrics were registered with, or
+// nil if it cannot be gathered.
+func (m *PrometheusMetrics) Gatherer() prometheus.Gatherer {
+	return m.gatherer
+}
+
+func (m *PrometheusMetrics) StreamStarted(processor string) {
+	m.streams.WithLabelValues(processor).Inc()
+}
+
+func (m *PrometheusMetrics) StreamFinished(processor string, duration time.Duration) {
+	m.streamDurations.WithLabelValues(processor).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) PhaseProcessed(processor string, phase int, duration time.Duration) {
+	m.phases.WithLabelValues(processor, PhaseName(phase)).Inc()
+	m.phaseDurations.WithLabelValues(processor, PhaseName(phase)).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) ImmediateResponse(processor string, phase int, status int32) {
+	m.immediateResponses.WithLabelValues(processor, PhaseName(phase), strconv.Itoa(int(status))).Inc()
+}
+
+func (m *PrometheusMetrics) HandlerError(processor string, phase int) {
+	m.handlerErrors.WithLabelValues(processor, PhaseName(phase)).Inc()
+}
@@ -0,0 +1,106 @@
+package extproc_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// freeAddr returns a local address nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// scrape fetches the metrics listener's exposition, waiting for it to
+// start.
+func scrape(t *testing.T, addr string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err == nil {
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(body)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics listener not serving: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsListenerServesRecorderRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := ep.NewPrometheusMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := freeAddr(t)
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "metered",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			return ctx.CancelRequest(403, nil, "")
+		},
+	}, ep.WithMetrics(metrics), ep.WithMetricsListener(addr))
+	h.Request("GET", "/").Run().AssertImmediateResponse(403)
+
+	body := scrape(t, addr)
+	for _, want := range []string{
+		`extproc_streams_total{processor="metered"} 1`,
+		`extproc_phases_total{phase="request_headers",processor="metered"} 1`,
+		`extproc_immediate_responses_total{phase="request_headers",processor="metered",status="403"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+	if strings.Contains(body, "go_goroutines") {
+		t.Error("metrics listener serves the default registry")
+	}
+}
+
+func TestPrometheusMetricsReuseRegistration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first, err := ep.NewPrometheusMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ep.NewPrometheusMetrics(reg)
+	if err != nil {
+		t.Fatalf("registering again: %v", err)
+	}
+	first.HandlerError("twice", ep.REQUEST_PHASE_REQUEST_HEADERS)
+	second.HandlerError("twice", ep.REQUEST_PHASE_REQUEST_HEADERS)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == "extproc_handler_errors_total" {
+			if got := f.GetMetric()[0].GetCounter().GetValue(); got != 2 {
+				t.Errorf("handler errors %v, want 2", got)
+			}
+			return
+		}
+	}
+	t.Error("handler errors not gathered")
+}
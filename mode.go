@@ -0,0 +1,125 @@
+package extproc
+
+import (
+	"errors"
+
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	errModeOverridePhase = errors.New("processing mode can only be overridden in the request headers phase")
+	errNoBaseMode        = errors.New("processing mode helpers need ProcessingOptions.ProcessingMode as the base mode")
+)
+
+// initProcessingMode sets the stream's effective processing mode from the
+// mode configured for the processor (if any), which should mirror the
+// processing_mode in the envoy filter config. Without one the mode is
+// unknown (nil).
+func (rc *RequestContext) initProcessingMode(base *filterv3.ProcessingMode) {
+	rc.modeOverridden = false
+	if base == nil {
+		rc.mode = nil
+		return
+	}
+	rc.mode = proto.Clone(base).(*filterv3.ProcessingMode)
+}
+
+// ProcessingMode returns a copy of the processing mode in effect for the
+// request, including any override. This is only as accurate as the
+// ProcessingMode given in the ProcessingOptions (empty if unset).
+func (rc *RequestContext) ProcessingMode() *filterv3.ProcessingMode {
+	if rc.mode == nil {
+		return &filterv3.ProcessingMode{}
+	}
+	return proto.Clone(rc.mode).(*filterv3.ProcessingMode)
+}
+
+// OverrideProcessingMode replaces the processing mode for the rest of the
+// request. envoy only honors mode overrides in responses to the request
+// headers phase, and only if the filter sets allow_mode_override.
+func (rc *RequestContext) OverrideProcessingMode(mode *filterv3.ProcessingMode) error {
+	if rc.phase != REQUEST_PHASE_REQUEST_HEADERS {
+		return errModeOverridePhase
+	}
+	if mode == nil {
+		return errors.New("processing mode override cannot be nil")
+	}
+	rc.mode = proto.Clone(mode).(*filterv3.ProcessingMode)
+	rc.modeOverridden = true
+	return nil
+}
+
+// overrideMode changes the mode in effect, which must be known: envoy
+// replaces the whole mode with an override, so starting from a guess
+// could turn off phases the filter config enables.
+func (rc *RequestContext) overrideMode(update func(*filterv3.ProcessingMode)) error {
+	if rc.mode == nil {
+		return errNoBaseMode
+	}
+	mode := rc.ProcessingMode()
+	update(mode)
+	return rc.OverrideProcessingMode(mode)
+}
+
+// SkipRequestBody overrides the processing mode to not send the request body.
+func (rc *RequestContext) SkipRequestBody() error {
+	return rc.overrideMode(func(m *filterv3.ProcessingMode) {
+		m.RequestBodyMode = filterv3.ProcessingMode_NONE
+	})
+}
+
+// BufferRequestBody overrides the processing mode to send the entire
+// request body in a single phase.
+func (rc *RequestContext) BufferRequestBody() error {
+	return rc.overrideMode(func(m *filterv3.ProcessingMode) {
+		m.RequestBodyMode = filterv3.ProcessingMode_BUFFERED
+	})
+}
+
+// StreamRequestBody overrides the processing mode to send the request
+// body in chunks as it arrives.
+func (rc *RequestContext) StreamRequestBody() error {
+	return rc.overrideMode(func(m *filterv3.ProcessingMode) {
+		m.RequestBodyMode = filterv3.ProcessingMode_STREAMED
+	})
+}
+
+// SkipResponseBody overrides the processing mode to not send the response body.
+func (rc *RequestContext) SkipResponseBody() error {
+	return rc.overrideMode(func(m *filterv3.ProcessingMode) {
+		m.ResponseBodyMode = filterv3.ProcessingMode_NONE
+	})
+}
+
+// BufferResponseBody overrides the processing mode to send the entire
+// response body in a single phase.
+func (rc *RequestContext) BufferResponseBody() error {
+	return rc.overrideMode(func(m *filterv3.ProcessingMode) {
+		m.ResponseBodyMode = filterv3.ProcessingMode_BUFFERED
+	})
+}
+
+// StreamResponseBody overrides the processing mode to send the response
+// body in chunks as it arrives.
+func (rc *RequestContext) StreamResponseBody() error {
+	return rc.overrideMode(func(m *filterv3.ProcessingMode) {
+		m.ResponseBodyMode = filterv3.ProcessingMode_STREAMED
+	})
+}
+
+// SkipResponseHeaders overrides the processing mode to not send the
+// response headers.
+func (rc *RequestContext) SkipResponseHeaders() error {
+	return rc.overrideMode(func(m *filterv3.ProcessingMode) {
+		m.ResponseHeaderMode = filterv3.ProcessingMode_SKIP
+	})
+}
+
+// modeOverride is the mode to send to envoy with the current response.
+func (rc *RequestContext) modeOverride() *filterv3.ProcessingMode {
+	if !rc.modeOverridden || rc.phase != REQUEST_PHASE_REQUEST_HEADERS {
+		return nil
+	}
+	return rc.mode
+}
@@ -0,0 +1,60 @@
+package extproc_test
+
+import (
+	"testing"
+
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+func skippingResponseBody(base *filterv3.ProcessingMode, err *error) *ep.ProcessorFuncs {
+	return &ep.ProcessorFuncs{
+		Name:    "skipping",
+		Options: &ep.ProcessingOptions{ProcessingMode: base},
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			*err = ctx.SkipResponseBody()
+			return ctx.ContinueRequest()
+		},
+	}
+}
+
+func TestModeHelperWithoutBaseMode(t *testing.T) {
+	var err error
+	res := extproctest.New(t, skippingResponseBody(nil, &err)).Request("GET", "/").Run()
+
+	res.AssertContinued()
+	if err == nil {
+		t.Error("SkipResponseBody succeeded without a base mode")
+	}
+	if mode := res.Phase(ep.REQUEST_PHASE_REQUEST_HEADERS).Responses[0].GetModeOverride(); mode != nil {
+		t.Errorf("mode override %v sent", mode)
+	}
+}
+
+func TestModeHelperKeepsBaseMode(t *testing.T) {
+	base := &filterv3.ProcessingMode{
+		RequestHeaderMode:  filterv3.ProcessingMode_SEND,
+		ResponseHeaderMode: filterv3.ProcessingMode_SEND,
+		RequestBodyMode:    filterv3.ProcessingMode_BUFFERED,
+		ResponseBodyMode:   filterv3.ProcessingMode_STREAMED,
+	}
+	var err error
+	res := extproctest.New(t, skippingResponseBody(base, &err)).Request("GET", "/").Run()
+
+	res.AssertContinued()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode := res.Phase(ep.REQUEST_PHASE_REQUEST_HEADERS).Responses[0].GetModeOverride()
+	if mode.GetRequestBodyMode() != filterv3.ProcessingMode_BUFFERED {
+		t.Errorf("request body mode %v, want the base mode's BUFFERED", mode.GetRequestBodyMode())
+	}
+	if mode.GetResponseBodyMode() != filterv3.ProcessingMode_NONE {
+		t.Errorf("response body mode %v, want NONE", mode.GetResponseBodyMode())
+	}
+	if base.ResponseBodyMode != filterv3.ProcessingMode_STREAMED {
+		t.Error("base mode changed")
+	}
+}
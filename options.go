@@ -1,10 +1,35 @@
 package extproc
 
+import (
+	"log/slog"
+
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+)
+
 type ProcessingOptions struct {
+	// Logger is used for all SDK logs and is the base for each request's
+	// RequestContext.Logger; slog.Default() is used when unset
+	Logger *slog.Logger
+
 	LogStream            bool
 	LogPhases            bool
 	UpdateExtProcHeader  bool
 	UpdateDurationHeader bool
+
+	// ErrorPolicy maps errors returned by phase handlers to responses;
+	// see ErrorPolicy for the behavior when unset
+	ErrorPolicy ErrorPolicy
+
+	// ProcessingMode should mirror the processing_mode of the envoy
+	// filter; it is the base for per-request mode overrides
+	ProcessingMode *filterv3.ProcessingMode
+
+	// BodyBuffer, when set, buffers streamed bodies for processors that
+	// implement RequestBodyProcessor or ResponseBodyProcessor
+	BodyBuffer *BodyBufferOptions
+
+	// BodyCodec, when set, decodes compressed bodies for processors
+	BodyCodec *BodyCodecOptions
 }
 
 func NewDefaultOptions() *ProcessingOptions {
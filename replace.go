@@ -0,0 +1,79 @@
+package extproc
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+var errReplaceAtHeadersPhase = errors.New("bodies can only be replaced at headers in headers phases")
+
+// ReplaceBodyAtHeaders replaces the whole body (request or response) from
+// a headers phase, e.g. to synthesize an upstream payload from query
+// parameters. The phase responds with the CONTINUE_AND_REPLACE status:
+// envoy sends body on instead of the original body, and skips the body
+// and trailers phases of that direction. content-length is set to the new
+// size, content-encoding is removed (the body is sent as is), and
+// content-type is set from the body (see http.DetectContentType) unless
+// present or already set. An empty body clears the body.
+//
+// To turn a bodyless request (e.g. GET) into one with a body, also change
+// :method, which envoy only allows if the filter's mutation_rules set
+// allow_all_routing.
+func (rc *RequestContext) ReplaceBodyAtHeaders(body []byte) error {
+	var headers Headers
+	switch rc.phase {
+	case REQUEST_PHASE_REQUEST_HEADERS:
+		headers = rc.Headers
+	case REQUEST_PHASE_RESPONSE_HEADERS:
+		headers = rc.responseHeaders
+	default:
+		return errReplaceAtHeadersPhase
+	}
+
+	if len(body) == 0 {
+		rc.response.bodyMutation = &extprocv3.BodyMutation{
+			Mutation: &extprocv3.BodyMutation_ClearBody{ClearBody: true},
+		}
+	} else {
+		rc.response.bodyMutation = &extprocv3.BodyMutation{
+			Mutation: &extprocv3.BodyMutation_Body{Body: body},
+		}
+		if !headers.Has("content-type") && !rc.settingHeader("content-type") {
+			rc.OverwriteHeader("content-type", HeaderValue{RawValue: []byte(http.DetectContentType(body))})
+		}
+	}
+	rc.response.replaceBody = true
+
+	if len(rc.ContentEncodings(rc.phase)) > 0 {
+		rc.RemoveHeader("content-encoding")
+	}
+
+	// replace rather than repeat a length set (or removed) earlier
+	hm := rc.response.headerMutation
+	hm.SetHeaders = slices.DeleteFunc(hm.SetHeaders, func(h *corev3.HeaderValueOption) bool {
+		return strings.EqualFold(h.GetHeader().GetKey(), kContentLength)
+	})
+	hm.RemoveHeaders = slices.DeleteFunc(hm.RemoveHeaders, func(name string) bool {
+		return strings.EqualFold(name, kContentLength)
+	})
+	rc.OverwriteHeader(kContentLength, HeaderValue{RawValue: []byte(strconv.Itoa(len(body)))})
+
+	rc.bodySize(rc.phase).rewritten = int64(len(body))
+	return nil
+}
+
+// settingHeader reports whether the phase response sets the named header.
+func (rc *RequestContext) settingHeader(name string) bool {
+	for _, h := range rc.response.headerMutation.GetSetHeaders() {
+		if strings.EqualFold(h.GetHeader().GetKey(), name) {
+			return true
+		}
+	}
+	return false
+}
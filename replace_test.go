@@ -0,0 +1,86 @@
+package extproc_test
+
+import (
+	"testing"
+
+	mutationrulesv3 "github.com/envoyproxy/go-control-plane/envoy/config/common/mutation_rules/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// queryToBody turns GET requests into POSTs with a JSON body.
+func queryToBody(bodyCalls *int) *ep.ProcessorFuncs {
+	return &ep.ProcessorFuncs{
+		Name: "query-to-body",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			ctx.OverwriteHeader(":method", ep.HeaderValue{RawValue: []byte("POST")})
+			if err := ctx.ReplaceBodyAtHeaders([]byte(`{"q":"x"}`)); err != nil {
+				return err
+			}
+			return ctx.ContinueRequest()
+		},
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			*bodyCalls++
+			return ctx.ContinueRequest()
+		},
+		ResponseHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			if err := ctx.ReplaceBodyAtHeaders(nil); err != nil {
+				return err
+			}
+			return ctx.ContinueRequest()
+		},
+	}
+}
+
+func TestReplaceBodyAtHeaders(t *testing.T) {
+	calls := 0
+	h := extproctest.New(t, queryToBody(&calls))
+	h.MutationRules = &mutationrulesv3.HeaderMutationRules{AllowAllRouting: wrapperspb.Bool(true)}
+	res := h.Request("GET", "/search?q=x").
+		Body("ignored").
+		Response(200).
+		Header("content-type", "text/html").
+		Header("content-length", "5").
+		Body("<p/>").
+		Run()
+
+	res.AssertContinued()
+	if calls != 0 {
+		t.Errorf("request body phase called %d times", calls)
+	}
+	res.AssertUpstreamHeader(":method", "POST")
+	res.AssertUpstreamHeader("content-type", "text/plain; charset=utf-8")
+	res.AssertUpstreamHeader("content-length", "9")
+	res.AssertUpstreamBody(`{"q":"x"}`)
+
+	res.AssertDownstreamHeader("content-type", "text/html")
+	res.AssertDownstreamHeader("content-length", "0")
+	res.AssertDownstreamBody("")
+}
+
+func TestReplaceBodyAtHeadersMethodNeedsRouting(t *testing.T) {
+	calls := 0
+	res := extproctest.New(t, queryToBody(&calls)).Request("GET", "/").Run()
+
+	res.AssertContinued()
+	res.AssertHeader(ep.REQUEST_PHASE_REQUEST_HEADERS, ":method", "POST")
+	res.AssertUpstreamHeader(":method", "GET")
+	res.AssertUpstreamBody(`{"q":"x"}`)
+}
+
+func TestReplaceBodyAtHeadersPhase(t *testing.T) {
+	var err error
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "replace",
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			err = ctx.ReplaceBodyAtHeaders([]byte("x"))
+			return ctx.ContinueRequest()
+		},
+	})
+	h.Request("POST", "/").Body("a").Run().AssertContinued()
+	if err == nil {
+		t.Error("ReplaceBodyAtHeaders allowed in a body phase")
+	}
+}
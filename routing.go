@@ -0,0 +1,50 @@
+package extproc
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errRouteCachePhase  = errors.New("the route cache can only be cleared in request headers or body phases")
+	errRewritePathPhase = errors.New("the path can only be rewritten in the request headers phase")
+)
+
+// ClearRouteCache has envoy recompute the route of the request after the
+// phase's header mutations, so they can change routing (e.g. the upstream
+// cluster). Only honored in request phases, and when the filter's
+// route_cache_action is DEFAULT.
+func (rc *RequestContext) ClearRouteCache() error {
+	switch rc.phase {
+	case REQUEST_PHASE_REQUEST_HEADERS, REQUEST_PHASE_REQUEST_BODY:
+	default:
+		return errRouteCachePhase
+	}
+	rc.response.clearRouteCache = true
+	return nil
+}
+
+// RouteToCluster routes the request to the named cluster by setting the
+// header that a route's cluster_header reads the cluster from, and
+// clearing the route cache.
+func (rc *RequestContext) RouteToCluster(headerName, cluster string) error {
+	if err := rc.ClearRouteCache(); err != nil {
+		return err
+	}
+	return rc.OverwriteHeader(headerName, HeaderValue{RawValue: []byte(cluster)})
+}
+
+// RewritePath changes the request's :path (including any query string),
+// clearing the route cache so the request is routed by the new path. The
+// RequestContext's Path and FullPath are updated for later processors.
+func (rc *RequestContext) RewritePath(newPath string) error {
+	if rc.phase != REQUEST_PHASE_REQUEST_HEADERS {
+		return errRewritePathPhase
+	}
+	if err := rc.ClearRouteCache(); err != nil {
+		return err
+	}
+	rc.FullPath = newPath
+	rc.Path = strings.Split(newPath, "?")[0]
+	return rc.OverwriteHeader(":path", HeaderValue{RawValue: []byte(newPath)})
+}
@@ -0,0 +1,91 @@
+package extproc_test
+
+import (
+	"testing"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+func TestRouteToCluster(t *testing.T) {
+	res := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "routing",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			if err := ctx.RouteToCluster("x-cluster", "canary"); err != nil {
+				return err
+			}
+			return ctx.ContinueRequest()
+		},
+	}).Request("GET", "/").Run()
+
+	res.AssertContinued()
+	res.AssertUpstreamHeader("x-cluster", "canary")
+	common := res.Phase(ep.REQUEST_PHASE_REQUEST_HEADERS).Responses[0].GetRequestHeaders().GetResponse()
+	if !common.GetClearRouteCache() {
+		t.Error("route cache not cleared")
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	var path, fullPath string
+	rewrite := &ep.ProcessorFuncs{
+		Name: "rewrite",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			if err := ctx.RewritePath("/v2" + ctx.FullPath); err != nil {
+				return err
+			}
+			return ctx.ContinueRequest()
+		},
+	}
+	after := &ep.ProcessorFuncs{
+		Name: "after",
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			path, fullPath = ctx.Path, ctx.FullPath
+			return ctx.ContinueRequest()
+		},
+	}
+	res := extproctest.New(t, ep.Chain(rewrite, after)).Request("GET", "/things?page=2").Run()
+
+	res.AssertContinued()
+	if got := res.Upstream().Headers.Pseudo("path"); got != "/v2/things?page=2" {
+		t.Errorf("upstream :path %q, want /v2/things?page=2", got)
+	}
+	if path != "/v2/things" || fullPath != "/v2/things?page=2" {
+		t.Errorf("later processor saw path %q and full path %q", path, fullPath)
+	}
+	if !res.Phase(ep.REQUEST_PHASE_REQUEST_HEADERS).Responses[0].GetRequestHeaders().GetResponse().GetClearRouteCache() {
+		t.Error("route cache not cleared")
+	}
+}
+
+func TestRoutingPhases(t *testing.T) {
+	var bodyClear, bodyRewrite, responseClear, responseRoute error
+	res := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "routing",
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			bodyClear = ctx.ClearRouteCache()
+			bodyRewrite = ctx.RewritePath("/elsewhere")
+			return ctx.ContinueRequest()
+		},
+		ResponseHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			responseClear = ctx.ClearRouteCache()
+			responseRoute = ctx.RouteToCluster("x-cluster", "canary")
+			return ctx.ContinueRequest()
+		},
+	}).Request("POST", "/").Body("data").Response(200).Run()
+
+	res.AssertContinued()
+	if bodyClear != nil {
+		t.Errorf("clearing the route cache in the request body: %v", bodyClear)
+	}
+	if !res.Phase(ep.REQUEST_PHASE_REQUEST_BODY).Responses[0].GetRequestBody().GetResponse().GetClearRouteCache() {
+		t.Error("route cache not cleared in the request body phase")
+	}
+	if bodyRewrite == nil || responseClear == nil || responseRoute == nil {
+		t.Errorf("routing changes accepted in later phases: %v, %v, %v", bodyRewrite, responseClear, responseRoute)
+	}
+	res.AssertNoHeader(ep.REQUEST_PHASE_RESPONSE_HEADERS, "x-cluster")
+	if got := res.Upstream().Headers.Pseudo("path"); got != "/" {
+		t.Errorf("upstream :path %q, want /", got)
+	}
+}
@@ -1,54 +1,391 @@
 package extproc
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 
 	epb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	hpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-func Serve(port int, processor RequestProcessor) {
+const (
+	kDefaultAddress         = ":50051"
+	kDefaultMaxStreams      = 1000
+	kDefaultShutdownTimeout = 10 * time.Second
+)
+
+// Option configures a Server (see NewServer and Serve).
+type Option func(*serverConfig)
+
+type serverConfig struct {
+	address            string
+	socketMode         fs.FileMode
+	maxStreams         uint32
+	shutdownTimeout    time.Duration
+	drainDelay         time.Duration
+	grpcOptions        []grpc.ServerOption
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+	keepalive          *keepalive.ServerParameters
+	keepalivePolicy    *keepalive.EnforcementPolicy
+	metrics            MetricsRecorder
+	metricsAddr        string
+	tlsCertFile        string
+	tlsKeyFile         string
+	tlsClientCAFile    string
+	tlsConfig          *tls.Config
+	tracerProvider     trace.TracerProvider
+	propagator         propagation.TextMapPropagator
+}
+
+func newServerConfig(opts []Option) *serverConfig {
+	cfg := &serverConfig{
+		address:         kDefaultAddress,
+		maxStreams:      kDefaultMaxStreams,
+		shutdownTimeout: kDefaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithAddress sets the address the server listens on when Serve is not
+// given a listener (default ":50051"). Unix domain sockets are supported
+// with "unix:///path/to/socket" addresses (see Listen).
+func WithAddress(addr string) Option {
+	return func(c *serverConfig) {
+		c.address = addr
+	}
+}
+
+// WithMaxConcurrentStreams limits concurrent streams (i.e. requests) per
+// envoy connection (default 1000).
+func WithMaxConcurrentStreams(n uint32) Option {
+	return func(c *serverConfig) {
+		c.maxStreams = n
+	}
+}
+
+// WithShutdownTimeout bounds how long Serve waits for in-flight streams
+// to drain once its context is cancelled (default 10s).
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(c *serverConfig) {
+		c.shutdownTimeout = d
+	}
+}
+
+// WithDrainDelay sets how long Shutdown reports NOT_SERVING on the health
+// service before it stops accepting streams, giving envoy's health checks
+// time to stop routing to the server (default 0).
+func WithDrainDelay(d time.Duration) Option {
+	return func(c *serverConfig) {
+		c.drainDelay = d
+	}
+}
+
+// WithGRPCServerOptions passes additional options to grpc.NewServer.
+func WithGRPCServerOptions(opts ...grpc.ServerOption) Option {
+	return func(c *serverConfig) {
+		c.grpcOptions = append(c.grpcOptions, opts...)
+	}
+}
+
+// WithUnaryInterceptors chains unary interceptors (used by the health service).
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(c *serverConfig) {
+		c.unaryInterceptors = append(c.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors chains stream interceptors (used by the ExtProc
+// service, where each stream is a request).
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(c *serverConfig) {
+		c.streamInterceptors = append(c.streamInterceptors, interceptors...)
+	}
+}
+
+// WithKeepalive sets the server's keepalive parameters.
+func WithKeepalive(params keepalive.ServerParameters) Option {
+	return func(c *serverConfig) {
+		c.keepalive = &params
+	}
+}
+
+// WithKeepaliveEnforcement sets the server's keepalive enforcement policy
+// for client (envoy) pings.
+func WithKeepaliveEnforcement(policy keepalive.EnforcementPolicy) Option {
+	return func(c *serverConfig) {
+		c.keepalivePolicy = &policy
+	}
+}
+
+// WithMetrics records stream and phase metrics with recorder.
+func WithMetrics(recorder MetricsRecorder) Option {
+	return func(c *serverConfig) {
+		c.metrics = recorder
+	}
+}
+
+// WithMetricsListener serves prometheus metrics at /metrics on addr (e.g.
+// ":9090"). If no recorder is given with WithMetrics, the SDK's
+// PrometheusMetrics are registered with the default registry. A recorder
+// with a Gatherer() prometheus.Gatherer method (as PrometheusMetrics
+// created with a *prometheus.Registry) has its registry served instead of
+// the default one.
+func WithMetricsListener(addr string) Option {
+	return func(c *serverConfig) {
+		c.metricsAddr = addr
+	}
+}
+
+// Server runs a RequestProcessor as an ExtProc gRPC service, along with
+// the gRPC health service. Unlike Serve, a Server does not handle signals
+// or exit the process, so it can be embedded in larger services.
+type Server struct {
+	extproc *GenericExtProcServer
+	grpc    *grpc.Server
+	health  *HealthServer
+	cfg     *serverConfig
+	logger  *slog.Logger
+
+	mu            sync.Mutex
+	metricsServer *http.Server
+}
+
+// NewServer creates a Server for processor configured with opts.
+func NewServer(processor RequestProcessor, opts ...Option) (*Server, error) {
 	if processor == nil {
-		log.Fatalf("cannot process request stream without `processor`")
+		return nil, errors.New("cannot process request stream without `processor`")
+	}
+
+	cfg := newServerConfig(opts)
+
+	// resolved once: streams only read the options
+	options := processor.GetOptions()
+	if options == nil {
+		options = NewDefaultOptions()
+	}
+
+	extproc := &GenericExtProcServer{
+		name:       processor.GetName(),
+		processor:  processor,
+		options:    options,
+		metrics:    cfg.metrics,
+		propagator: cfg.propagator,
+		phases:     processedPhases(processor),
+	}
+	if cfg.tracerProvider != nil {
+		extproc.tracer = cfg.tracerProvider.Tracer(tracerName)
+	}
+	if cfg.metricsAddr != "" && extproc.metrics == nil {
+		metrics, err := NewPrometheusMetrics(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+		extproc.metrics = metrics
 	}
+	logger := extproc.logger()
 
-	lis, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	sopts := []grpc.ServerOption{grpc.MaxConcurrentStreams(cfg.maxStreams)}
+	tlsConfig, err := cfg.serverTLSConfig(logger)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return nil, err
+	}
+	if tlsConfig != nil {
+		sopts = append(sopts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if cfg.keepalive != nil {
+		sopts = append(sopts, grpc.KeepaliveParams(*cfg.keepalive))
+	}
+	if cfg.keepalivePolicy != nil {
+		sopts = append(sopts, grpc.KeepaliveEnforcementPolicy(*cfg.keepalivePolicy))
+	}
+	if len(cfg.unaryInterceptors) > 0 {
+		sopts = append(sopts, grpc.ChainUnaryInterceptor(cfg.unaryInterceptors...))
+	}
+	if len(cfg.streamInterceptors) > 0 {
+		sopts = append(sopts, grpc.ChainStreamInterceptor(cfg.streamInterceptors...))
+	}
+	sopts = append(sopts, cfg.grpcOptions...)
+
+	checker, _ := processor.(HealthChecker)
+	s := &Server{
+		extproc: extproc,
+		grpc:    grpc.NewServer(sopts...),
+		health:  NewHealthServer(checker, logger, extproc.name, epb.ExternalProcessor_ServiceDesc.ServiceName),
+		cfg:     cfg,
+		logger:  logger,
 	}
+	epb.RegisterExternalProcessorServer(s.grpc, s.extproc)
+	hpb.RegisterHealthServer(s.grpc, s.health)
+	return s, nil
+}
+
+// Health returns the server's health service, e.g. to set the status of
+// additional services.
+func (s *Server) Health() *HealthServer {
+	return s.health
+}
 
-	sopts := []grpc.ServerOption{grpc.MaxConcurrentStreams(1000)}
-	s := grpc.NewServer(sopts...)
+// GRPCServer returns the underlying gRPC server, e.g. to register
+// additional services before calling Serve.
+func (s *Server) GRPCServer() *grpc.Server {
+	return s.grpc
+}
 
-	name := processor.GetName()
-	opts := processor.GetOptions() // TODO: figure out command line overrides
-	extproc := &GenericExtProcServer{
-		name:      name,
-		processor: processor,
-		options:   opts,
+// Serve accepts ExtProc streams on lis (or on the configured address if
+// lis is nil) until the server is shut down or ctx is cancelled, in which
+// case in-flight streams are drained for up to the shutdown timeout.
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	if lis == nil {
+		var err error
+		lis, err = Listen(s.cfg.address, s.cfg.socketMode)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.cfg.address, err)
+		}
 	}
-	epb.RegisterExternalProcessorServer(s, extproc)
-	hpb.RegisterHealthServer(s, &HealthServer{})
 
-	log.Printf("Starting ExtProc(%s) on port %d\n", name, port)
+	s.startMetrics()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.grpc.Serve(lis)
+	}()
+	s.logger.Info("starting ExtProc", "address", lis.Addr().String())
+	s.health.Resume()
+
+	select {
+	case err := <-errc:
+		s.stopMetrics(context.Background())
+		return err
+
+	case <-ctx.Done():
+		sctx, cancel := context.WithTimeout(context.Background(), s.cfg.shutdownTimeout)
+		defer cancel()
+		err := s.Shutdown(sctx)
+		<-errc
+		return err
+	}
+}
+
+// Shutdown reports NOT_SERVING on the health service, then stops accepting
+// new streams and waits for in-flight streams to finish. If ctx expires
+// first, remaining streams are cancelled and the context's error is
+// returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("shutting down ExtProc")
+	s.health.Shutdown()
 
-	go s.Serve(lis)
+	if s.cfg.drainDelay > 0 {
+		select {
+		case <-time.After(s.cfg.drainDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("shutdown deadline exceeded, cancelling in-flight streams")
+		s.grpc.Stop()
+		<-done
+		err = ctx.Err()
+	}
+
+	s.stopMetrics(ctx)
+	return err
+}
+
+func (s *Server) startMetrics() {
+	if s.cfg.metricsAddr == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metricsServer == nil {
+		s.metricsServer = serveMetrics(s.cfg.metricsAddr, s.metricsGatherer(), s.logger)
+	}
+}
+
+// metricsGatherer returns the registry of the metrics recorder, if it has
+// one, or the default registry.
+func (s *Server) metricsGatherer() prometheus.Gatherer {
+	if r, ok := s.extproc.metrics.(interface{ Gatherer() prometheus.Gatherer }); ok {
+		if g := r.Gatherer(); g != nil {
+			return g
+		}
+	}
+	return prometheus.DefaultGatherer
+}
+
+func (s *Server) stopMetrics(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metricsServer != nil {
+		s.metricsServer.Shutdown(ctx)
+		s.metricsServer = nil
+	}
+}
+
+// Serve runs processor on port until the process receives SIGINT or
+// SIGTERM, then drains in-flight streams. Errors are fatal; use NewServer
+// for control over listeners, signals and shutdown.
+func Serve(port int, processor RequestProcessor, opts ...Option) {
+	opts = append([]Option{WithAddress(":" + strconv.Itoa(port))}, opts...)
+	srv, err := NewServer(processor, opts...)
+	if err != nil {
+		slog.Error("failed to create ExtProc server", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if err := srv.Serve(ctx, nil); err != nil {
+		srv.logger.Error("ExtProc server failed", "error", err)
+		os.Exit(1)
+	}
+}
 
-	gracefulStop := make(chan os.Signal, 1)
-	signal.Notify(gracefulStop, syscall.SIGTERM)
-	signal.Notify(gracefulStop, syscall.SIGINT)
-	sig := <-gracefulStop
-	log.Printf("caught sig: %+v", sig)
-	log.Println("Wait for 1 second to finish processing")
-	lis.Close()
+func serveMetrics(addr string, gatherer prometheus.Gatherer, logger *slog.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
 
-	time.Sleep(1 * time.Second)
+	logger.Info("serving metrics", "address", addr)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics listener failed", "address", addr, "error", err)
+		}
+	}()
+	return srv
 }
@@ -0,0 +1,148 @@
+package extproc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+// startStream serves server in-process until it is shut down and starts
+// a Process stream to it.
+func startStream(t *testing.T, server *ep.Server) (extprocv3.ExternalProcessor_ProcessClient, <-chan error) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(context.Background(), lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///server",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		conn.Close()
+		server.GRPCServer().Stop()
+	})
+
+	stream, err := extprocv3.NewExternalProcessorClient(conn).Process(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream, served
+}
+
+func headersRequest(response bool) *extprocv3.ProcessingRequest {
+	headers := &extprocv3.HttpHeaders{
+		Headers:     &corev3.HeaderMap{Headers: []*corev3.HeaderValue{{Key: ":method", RawValue: []byte("GET")}}},
+		EndOfStream: true,
+	}
+	if response {
+		return &extprocv3.ProcessingRequest{Request: &extprocv3.ProcessingRequest_ResponseHeaders{ResponseHeaders: headers}}
+	}
+	return &extprocv3.ProcessingRequest{Request: &extprocv3.ProcessingRequest_RequestHeaders{RequestHeaders: headers}}
+}
+
+func healthStatus(t *testing.T, server *ep.Server) pb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := server.Health().Check(context.Background(), &pb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Status
+}
+
+func TestShutdownDrainsStreams(t *testing.T) {
+	server, err := ep.NewServer(&ep.ProcessorFuncs{Name: "draining"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, served := startStream(t, server)
+	exchange(t, stream, headersRequest(false))
+	if got := healthStatus(t, server); got != pb.HealthCheckResponse_SERVING {
+		t.Fatalf("health %v while serving, want SERVING", got)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for healthStatus(t, server) != pb.HealthCheckResponse_NOT_SERVING {
+		if time.Now().After(deadline) {
+			t.Fatal("health still SERVING during shutdown")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned %v with a stream in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the in-flight stream completes
+	exchange(t, stream, headersRequest(true))
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("stream ended with %v, want EOF", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("shutdown error %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve error %v", err)
+	}
+}
+
+func TestShutdownDeadlineCancelsStreams(t *testing.T) {
+	server, err := ep.NewServer(&ep.ProcessorFuncs{Name: "draining"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, served := startStream(t, server)
+	exchange(t, stream, headersRequest(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("shutdown error %v, want deadline exceeded", err)
+	}
+	if _, err := stream.Recv(); err == nil || err == io.EOF {
+		t.Errorf("stream ended with %v after the deadline, want an error", err)
+	}
+	<-served
+}
+
+func TestConcurrentStreamsWithDefaultOptions(t *testing.T) {
+	// a processor without options: streams share the resolved defaults
+	h := extproctest.New(t, &ep.ProcessorFuncs{Name: "defaults"})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Request("GET", "/").Response(200).Run().AssertContinued()
+		}()
+	}
+	wg.Wait()
+}
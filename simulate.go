@@ -0,0 +1,296 @@
+package extproc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mutationrulesv3 "github.com/envoyproxy/go-control-plane/envoy/config/common/mutation_rules/v3"
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	matcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+)
+
+var ErrMutationDisallowed = errors.New("header mutation disallowed")
+
+// HTTPMessage is a request or response as envoy holds it while processing.
+type HTTPMessage struct {
+	Headers  Headers // including pseudo-headers (":path", ":status", ...)
+	Body     []byte
+	Trailers Headers
+}
+
+// Simulation applies processing responses to a request and its response
+// as envoy would, so tests can check what the upstream and the client get
+// rather than the mutations in responses. Feed it every phase envoy sends,
+// in order, with Apply.
+type Simulation struct {
+	// Request is the request as sent upstream, and Response the response
+	// as sent to the client (unless LocalReply is set).
+	Request  HTTPMessage
+	Response HTTPMessage
+
+	// LocalReply is the response envoy sends the client instead of
+	// proxying, after an ImmediateResponse or a failed mutation.
+	LocalReply *HTTPMessage
+
+	// RouteCacheCleared reports whether a request phase cleared the route
+	// cache (envoy ignores the flag in response phases).
+	RouteCacheCleared bool
+
+	// ProcessingMode should mirror the filter's processing_mode (with
+	// any overrides): header mutations in body phases are only applied
+	// with BUFFERED or BUFFERED_PARTIAL bodies, since envoy has sent the
+	// headers on otherwise. When nil they are always applied.
+	ProcessingMode *filterv3.ProcessingMode
+
+	// MutationRules should mirror the filter's mutation_rules; envoy's
+	// defaults (e.g. :method, host and x-envoy-* headers cannot be
+	// changed) apply when nil.
+	MutationRules *mutationrulesv3.HeaderMutationRules
+
+	requestReplaced  bool // CONTINUE_AND_REPLACE in request headers
+	responseReplaced bool // CONTINUE_AND_REPLACE in response headers
+}
+
+// Apply simulates a phase: envoy sending req and applying the processor's
+// responses to it (usually one; full duplex body chunks get any number,
+// possibly none). Headers and trailers phases set the message's headers
+// or trailers from req, and body phases append the (mutated) chunk to its
+// body. A mutation failing the MutationRules is returned as an error, and
+// sets a 500 LocalReply, as envoy fails the request.
+func (s *Simulation) Apply(req *extprocv3.ProcessingRequest, resps ...*extprocv3.ProcessingResponse) error {
+	var (
+		msg    *HTTPMessage
+		chunk  []byte
+		isBody bool
+		common *extprocv3.CommonResponse
+	)
+
+	switch r := req.Request.(type) {
+	case *extprocv3.ProcessingRequest_RequestHeaders:
+		msg = &s.Request
+		msg.Headers = newHeaders(r.RequestHeaders.Headers)
+	case *extprocv3.ProcessingRequest_ResponseHeaders:
+		msg = &s.Response
+		msg.Headers = newHeaders(r.ResponseHeaders.Headers)
+	case *extprocv3.ProcessingRequest_RequestBody:
+		if s.requestReplaced {
+			return nil
+		}
+		msg, chunk, isBody = &s.Request, r.RequestBody.Body, true
+	case *extprocv3.ProcessingRequest_ResponseBody:
+		if s.responseReplaced {
+			return nil
+		}
+		msg, chunk, isBody = &s.Response, r.ResponseBody.Body, true
+	case *extprocv3.ProcessingRequest_RequestTrailers:
+		msg = &s.Request
+		msg.Trailers = newHeaders(r.RequestTrailers.Trailers)
+	case *extprocv3.ProcessingRequest_ResponseTrailers:
+		msg = &s.Response
+		msg.Trailers = newHeaders(r.ResponseTrailers.Trailers)
+	default:
+		return fmt.Errorf("cannot simulate processing request %T", req.Request)
+	}
+
+	if isBody && len(resps) == 0 && !s.fullDuplex(msg) {
+		// no response (e.g. the phase failed): the chunk is passed on
+		msg.Body = append(msg.Body, chunk...)
+	}
+
+	for _, resp := range resps {
+		if ir := resp.GetImmediateResponse(); ir != nil {
+			return s.localReply(ir)
+		}
+
+		switch r := resp.Response.(type) {
+		case *extprocv3.ProcessingResponse_RequestHeaders:
+			common = r.RequestHeaders.GetResponse()
+		case *extprocv3.ProcessingResponse_ResponseHeaders:
+			common = r.ResponseHeaders.GetResponse()
+		case *extprocv3.ProcessingResponse_RequestBody:
+			common = r.RequestBody.GetResponse()
+		case *extprocv3.ProcessingResponse_ResponseBody:
+			common = r.ResponseBody.GetResponse()
+		case *extprocv3.ProcessingResponse_RequestTrailers:
+			return s.mutate(&msg.Trailers, r.RequestTrailers.GetHeaderMutation())
+		case *extprocv3.ProcessingResponse_ResponseTrailers:
+			return s.mutate(&msg.Trailers, r.ResponseTrailers.GetHeaderMutation())
+		default:
+			return fmt.Errorf("cannot simulate processing response %T", resp.Response)
+		}
+
+		// header mutations of streamed body phases come too late
+		if !isBody || s.bufferedBody(msg) {
+			if err := s.mutate(&msg.Headers, common.GetHeaderMutation()); err != nil {
+				return err
+			}
+		}
+
+		body, mutated := mutatedChunk(common.GetBodyMutation(), chunk)
+		if common.GetStatus() == extprocv3.CommonResponse_CONTINUE_AND_REPLACE && !isBody {
+			// the body is replaced whole, and its body phases skipped
+			msg.Body = body
+			if msg == &s.Request {
+				s.requestReplaced = true
+			} else {
+				s.responseReplaced = true
+			}
+		} else if isBody {
+			if !mutated && s.fullDuplex(msg) {
+				body = nil
+			}
+			msg.Body = append(msg.Body, body...)
+		}
+
+		if common.GetClearRouteCache() && msg == &s.Request {
+			s.RouteCacheCleared = true
+		}
+		// NOTE: envoy does not implement CommonResponse.trailers
+	}
+	return nil
+}
+
+// mutatedChunk returns a body chunk after mutation, and whether the
+// mutation changes it.
+func mutatedChunk(m *extprocv3.BodyMutation, chunk []byte) ([]byte, bool) {
+	switch b := m.GetMutation().(type) {
+	case *extprocv3.BodyMutation_Body:
+		return b.Body, true
+	case *extprocv3.BodyMutation_ClearBody:
+		if b.ClearBody {
+			return nil, true
+		}
+	case *extprocv3.BodyMutation_StreamedResponse:
+		return b.StreamedResponse.GetBody(), true
+	}
+	return chunk, false
+}
+
+func (s *Simulation) bodyMode(msg *HTTPMessage) filterv3.ProcessingMode_BodySendMode {
+	if msg == &s.Request {
+		return s.ProcessingMode.GetRequestBodyMode()
+	}
+	return s.ProcessingMode.GetResponseBodyMode()
+}
+
+func (s *Simulation) bufferedBody(msg *HTTPMessage) bool {
+	if s.ProcessingMode == nil {
+		return true
+	}
+	switch s.bodyMode(msg) {
+	case filterv3.ProcessingMode_BUFFERED, filterv3.ProcessingMode_BUFFERED_PARTIAL:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Simulation) fullDuplex(msg *HTTPMessage) bool {
+	return s.bodyMode(msg) == filterv3.ProcessingMode_FULL_DUPLEX_STREAMED
+}
+
+// localReply simulates envoy replying to the client with an immediate
+// response.
+func (s *Simulation) localReply(ir *extprocv3.ImmediateResponse) error {
+	code := int(ir.GetStatus().GetCode())
+	if code == 0 {
+		code = http.StatusOK
+	}
+
+	reply := &HTTPMessage{Body: ir.GetBody()}
+	reply.Headers.add(HeaderField{Name: ":status", Value: strconv.Itoa(code)})
+	s.LocalReply = reply
+	if err := s.mutate(&reply.Headers, ir.GetHeaders()); err != nil {
+		return err
+	}
+	if ir.GetGrpcStatus() != nil {
+		reply.Headers.remove("grpc-status")
+		reply.Headers.add(HeaderField{Name: "grpc-status", Value: strconv.Itoa(int(ir.GetGrpcStatus().GetStatus()))})
+	}
+	if len(reply.Body) > 0 && !reply.Headers.Has("content-type") {
+		reply.Headers.add(HeaderField{Name: "content-type", Value: "text/plain"})
+	}
+	return nil
+}
+
+// fail simulates envoy failing the request on a disallowed mutation.
+func (s *Simulation) fail(err error) error {
+	s.LocalReply = &HTTPMessage{}
+	s.LocalReply.Headers.add(HeaderField{Name: ":status", Value: strconv.Itoa(http.StatusInternalServerError)})
+	return err
+}
+
+// mutate applies a header mutation as envoy does: removals first, then
+// set headers, each subject to the mutation rules.
+func (s *Simulation) mutate(headers *Headers, hm *extprocv3.HeaderMutation) error {
+	for _, name := range hm.GetRemoveHeaders() {
+		name = strings.ToLower(name)
+		ok, err := s.allowed(name, "", true)
+		if err != nil {
+			return s.fail(err)
+		}
+		if ok {
+			headers.remove(name)
+		}
+	}
+
+	for _, h := range hm.GetSetHeaders() {
+		name, value := headerOption(h)
+		ok, err := s.allowed(name, value, false)
+		if err != nil {
+			return s.fail(err)
+		}
+		if !ok {
+			continue
+		}
+
+		headers.set(h)
+	}
+	return nil
+}
+
+// allowed checks a header mutation against the mutation rules, returning
+// an error if it must fail the request rather than be ignored.
+func (s *Simulation) allowed(name, value string, remove bool) (bool, error) {
+	if name == "" || strings.ContainsAny(value, "\r\n\x00") {
+		return false, fmt.Errorf("%w: invalid header %q", ErrMutationDisallowed, name)
+	}
+
+	rules := s.MutationRules
+	ok := true
+	switch {
+	case remove && (strings.HasPrefix(name, ":") || name == "host"):
+		// system headers can be changed, but never removed
+		ok = false
+	case matches(rules.GetDisallowExpression(), name):
+		ok = false
+	case matches(rules.GetAllowExpression(), name):
+		ok = true
+	case rules.GetDisallowAll().GetValue():
+		ok = false
+	case strings.HasPrefix(name, ":") && rules.GetDisallowSystem().GetValue():
+		ok = false
+	case name == "host" || name == ":authority" || name == ":scheme" || name == ":method":
+		ok = rules.GetAllowAllRouting().GetValue()
+	case strings.HasPrefix(name, "x-envoy-"):
+		ok = rules.GetAllowEnvoy().GetValue()
+	}
+
+	if !ok && rules.GetDisallowIsError().GetValue() {
+		return false, fmt.Errorf("%w: %q", ErrMutationDisallowed, name)
+	}
+	return ok, nil
+}
+
+func matches(m *matcherv3.RegexMatcher, name string) bool {
+	if m.GetRegex() == "" {
+		return false
+	}
+	re, err := regexp.Compile("^(?:" + m.GetRegex() + ")$")
+	return err == nil && re.MatchString(name)
+}
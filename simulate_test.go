@@ -0,0 +1,218 @@
+package extproc_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	mutationrulesv3 "github.com/envoyproxy/go-control-plane/envoy/config/common/mutation_rules/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	matcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+func requestHeaders(kvs ...string) *extprocv3.ProcessingRequest {
+	headers := []*corev3.HeaderValue{
+		{Key: ":method", RawValue: []byte("GET")},
+		{Key: ":path", RawValue: []byte("/")},
+	}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		headers = append(headers, &corev3.HeaderValue{Key: kvs[i], RawValue: []byte(kvs[i+1])})
+	}
+	return &extprocv3.ProcessingRequest{
+		Request: &extprocv3.ProcessingRequest_RequestHeaders{
+			RequestHeaders: &extprocv3.HttpHeaders{Headers: &corev3.HeaderMap{Headers: headers}},
+		},
+	}
+}
+
+func headersResponse(common *extprocv3.CommonResponse) *extprocv3.ProcessingResponse {
+	return &extprocv3.ProcessingResponse{
+		Response: &extprocv3.ProcessingResponse_RequestHeaders{
+			RequestHeaders: &extprocv3.HeadersResponse{Response: common},
+		},
+	}
+}
+
+func mutation(remove []string, set ...*corev3.HeaderValueOption) *extprocv3.ProcessingResponse {
+	return headersResponse(&extprocv3.CommonResponse{
+		HeaderMutation: &extprocv3.HeaderMutation{SetHeaders: set, RemoveHeaders: remove},
+	})
+}
+
+func option(name, value string, action corev3.HeaderValueOption_HeaderAppendAction) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: name, RawValue: []byte(value)},
+		AppendAction: action,
+	}
+}
+
+func TestSimulationAppendActions(t *testing.T) {
+	tests := []struct {
+		action   corev3.HeaderValueOption_HeaderAppendAction
+		existing []string
+		want     []string
+	}{
+		{corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD, nil, []string{"new"}},
+		{corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD, []string{"old"}, []string{"old", "new"}},
+		{corev3.HeaderValueOption_ADD_IF_ABSENT, nil, []string{"new"}},
+		{corev3.HeaderValueOption_ADD_IF_ABSENT, []string{"old"}, []string{"old"}},
+		{corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD, nil, []string{"new"}},
+		{corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD, []string{"a", "b"}, []string{"new"}},
+		{corev3.HeaderValueOption_OVERWRITE_IF_EXISTS, nil, nil},
+		{corev3.HeaderValueOption_OVERWRITE_IF_EXISTS, []string{"old"}, []string{"new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action.String(), func(t *testing.T) {
+			var kvs []string
+			for _, v := range tt.existing {
+				kvs = append(kvs, "x-h", v)
+			}
+			sim := &ep.Simulation{}
+			err := sim.Apply(requestHeaders(kvs...), mutation(nil, option("X-H", "new", tt.action)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sim.Request.Headers.Values("x-h"); !slices.Equal(got, tt.want) {
+				t.Errorf("%v on %q: got %q, want %q", tt.action, tt.existing, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimulationMutationRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  *mutationrulesv3.HeaderMutationRules
+		remove []string
+		set    *corev3.HeaderValueOption
+		check  func(t *testing.T, sim *ep.Simulation)
+		err    bool
+	}{
+		{
+			name:   "remove",
+			remove: []string{"X-A"},
+			check: func(t *testing.T, sim *ep.Simulation) {
+				if sim.Request.Headers.Has("x-a") {
+					t.Error("x-a not removed")
+				}
+			},
+		},
+		{
+			name:   "system header removal ignored",
+			rules:  &mutationrulesv3.HeaderMutationRules{AllowExpression: &matcherv3.RegexMatcher{Regex: ".*"}},
+			remove: []string{":path"},
+			check: func(t *testing.T, sim *ep.Simulation) {
+				if sim.Request.Headers.Pseudo(":path") != "/" {
+					t.Error(":path removed")
+				}
+			},
+		},
+		{
+			name: "envoy headers ignored by default",
+			set:  option("x-envoy-retry-on", "5xx", corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD),
+			check: func(t *testing.T, sim *ep.Simulation) {
+				if sim.Request.Headers.Has("x-envoy-retry-on") {
+					t.Error("x-envoy-retry-on set")
+				}
+			},
+		},
+		{
+			name:  "envoy headers allowed",
+			rules: &mutationrulesv3.HeaderMutationRules{AllowEnvoy: wrapperspb.Bool(true)},
+			set:   option("x-envoy-retry-on", "5xx", corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD),
+			check: func(t *testing.T, sim *ep.Simulation) {
+				if sim.Request.Headers.Get("x-envoy-retry-on") != "5xx" {
+					t.Error("x-envoy-retry-on not set")
+				}
+			},
+		},
+		{
+			name:  "disallow expression",
+			rules: &mutationrulesv3.HeaderMutationRules{DisallowExpression: &matcherv3.RegexMatcher{Regex: "x-a|x-b"}},
+			set:   option("x-a", "2", corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD),
+			check: func(t *testing.T, sim *ep.Simulation) {
+				if sim.Request.Headers.Get("x-a") != "1" {
+					t.Error("x-a changed")
+				}
+			},
+		},
+		{
+			name: "disallow is error",
+			rules: &mutationrulesv3.HeaderMutationRules{
+				DisallowAll:     wrapperspb.Bool(true),
+				DisallowIsError: wrapperspb.Bool(true),
+			},
+			set: option("x-a", "2", corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD),
+			err: true,
+			check: func(t *testing.T, sim *ep.Simulation) {
+				if sim.LocalReply == nil || sim.LocalReply.Headers.Pseudo(":status") != "500" {
+					t.Errorf("local reply %v, want 500", sim.LocalReply)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := &ep.Simulation{MutationRules: tt.rules}
+			var set []*corev3.HeaderValueOption
+			if tt.set != nil {
+				set = append(set, tt.set)
+			}
+			err := sim.Apply(requestHeaders("x-a", "1"), mutation(tt.remove, set...))
+			if got := errors.Is(err, ep.ErrMutationDisallowed); got != tt.err {
+				t.Errorf("Apply() error %v, want ErrMutationDisallowed: %v", err, tt.err)
+			}
+			tt.check(t, sim)
+		})
+	}
+}
+
+func TestSimulationClearRouteCache(t *testing.T) {
+	sim := &ep.Simulation{}
+	if err := sim.Apply(requestHeaders(), headersResponse(&extprocv3.CommonResponse{ClearRouteCache: true})); err != nil {
+		t.Fatal(err)
+	}
+	if !sim.RouteCacheCleared {
+		t.Error("route cache not cleared")
+	}
+}
+
+func TestSimulationLocalReply(t *testing.T) {
+	sim := &ep.Simulation{}
+	err := sim.Apply(requestHeaders(), &extprocv3.ProcessingResponse{
+		Response: &extprocv3.ProcessingResponse_ImmediateResponse{
+			ImmediateResponse: &extprocv3.ImmediateResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized},
+				Headers: &extprocv3.HeaderMutation{SetHeaders: []*corev3.HeaderValueOption{
+					option("www-authenticate", "Bearer", corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD),
+				}},
+				Body: []byte("no"),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := sim.LocalReply
+	if reply == nil {
+		t.Fatal("no local reply")
+	}
+	if got := reply.Headers.Pseudo(":status"); got != "401" {
+		t.Errorf(":status %q, want 401", got)
+	}
+	if got := reply.Headers.Get("www-authenticate"); got != "Bearer" {
+		t.Errorf("www-authenticate %q", got)
+	}
+	if got := reply.Headers.Get("content-type"); got != "text/plain" {
+		t.Errorf("content-type %q, want text/plain", got)
+	}
+	if string(reply.Body) != "no" {
+		t.Errorf("body %q", reply.Body)
+	}
+}
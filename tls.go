@@ -0,0 +1,167 @@
+package extproc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// how often certificate files are checked for changes, at most
+const kCertCheckInterval = time.Second
+
+// WithTLS serves TLS with the certificate and key in the given PEM files.
+// The files are watched and reloaded when they change on disk (e.g. when
+// rotated by cert-manager), without restarting the server.
+func WithTLS(certFile, keyFile string) Option {
+	return func(c *serverConfig) {
+		c.tlsCertFile = certFile
+		c.tlsKeyFile = keyFile
+	}
+}
+
+// WithClientCA requires clients (envoy) to present a certificate signed
+// by a CA in the given PEM bundle, i.e. mutual TLS. Requires WithTLS. The
+// bundle is reloaded when it changes on disk.
+func WithClientCA(caFile string) Option {
+	return func(c *serverConfig) {
+		c.tlsClientCAFile = caFile
+	}
+}
+
+// WithTLSConfig serves TLS with a custom configuration, for cases not
+// covered by WithTLS and WithClientCA. Takes precedence over both.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *serverConfig) {
+		c.tlsConfig = config
+	}
+}
+
+// serverTLSConfig returns the TLS configuration for the server, or nil to
+// serve plaintext.
+func (c *serverConfig) serverTLSConfig(logger *slog.Logger) (*tls.Config, error) {
+	if c.tlsConfig != nil {
+		return c.tlsConfig, nil
+	}
+	if c.tlsCertFile == "" && c.tlsKeyFile == "" {
+		if c.tlsClientCAFile != "" {
+			return nil, errors.New("client CA verification requires a server certificate and key")
+		}
+		return nil, nil
+	}
+	r := &certReloader{
+		certFile: c.tlsCertFile,
+		keyFile:  c.tlsKeyFile,
+		caFile:   c.tlsClientCAFile,
+		logger:   logger,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.configForClient,
+	}, nil
+}
+
+// certReloader serves the current certificate (and client CAs) from
+// files, reloading them when their modification times change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   *slog.Logger
+
+	mu        sync.RWMutex
+	config    *tls.Config
+	modTimes  []time.Time
+	checkedAt time.Time
+}
+
+func (r *certReloader) files() []string {
+	if r.caFile == "" {
+		return []string{r.certFile, r.keyFile}
+	}
+	return []string{r.certFile, r.keyFile, r.caFile}
+}
+
+func (r *certReloader) load() error {
+	modTimes := make([]time.Time, 0, 3)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"}, // required by gRPC
+	}
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("cannot read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA bundle %s", r.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = config
+	r.modTimes = modTimes
+	r.checkedAt = time.Now()
+	return nil
+}
+
+// changed reports whether any file changed since the last load, checking
+// at most once per kCertCheckInterval.
+func (r *certReloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < kCertCheckInterval {
+		return false
+	}
+	r.checkedAt = time.Now()
+	for i, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			// likely mid-rotation, try again on a later handshake
+			return false
+		}
+		if !info.ModTime().Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	if r.changed() {
+		if err := r.load(); err != nil {
+			// keep serving the previous certificate
+			r.logger.Error("failed to reload TLS certificates", "error", err)
+		} else {
+			r.logger.Info("reloaded TLS certificates", "cert", r.certFile)
+		}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config, nil
+}
@@ -0,0 +1,224 @@
+package extproc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	pb "google.golang.org/grpc/health/grpc_health_v1"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+)
+
+// testCA issues certificates for localhost.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pool: pool,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns the PEM certificate and key of a leaf named cn.
+func (ca *testCA) issue(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes data to path with a modification time of at, so
+// rewrites are detected regardless of the filesystem's time resolution.
+func writeFile(t *testing.T, path string, data []byte, at time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS serves a processor configured with opts on a local TCP port
+// until the test ends, returning its address.
+func serveTLS(t *testing.T, opts ...ep.Option) string {
+	t.Helper()
+	server, err := ep.NewServer(&ep.ProcessorFuncs{Name: "tls"}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, lis) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("serve error %v", err)
+		}
+	})
+	return lis.Addr().String()
+}
+
+// servedName returns the common name of the certificate served at addr.
+func servedName(t *testing.T, addr string, roots *x509.CertPool) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "localhost", NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	at := time.Now().Add(-time.Minute)
+	cert, key := ca.issue(t, "first")
+	writeFile(t, certFile, cert, at)
+	writeFile(t, keyFile, key, at)
+
+	addr := serveTLS(t, ep.WithTLS(certFile, keyFile))
+	if got := servedName(t, addr, ca.pool); got != "first" {
+		t.Fatalf("served %q, want first", got)
+	}
+
+	// rotated files are served from the next handshake after the check
+	// interval
+	cert, key = ca.issue(t, "second")
+	at = at.Add(time.Second)
+	writeFile(t, certFile, cert, at)
+	writeFile(t, keyFile, key, at)
+	time.Sleep(1100 * time.Millisecond)
+	if got := servedName(t, addr, ca.pool); got != "second" {
+		t.Errorf("served %q after rotation, want second", got)
+	}
+
+	// invalid files keep the previous certificate
+	at = at.Add(time.Second)
+	writeFile(t, certFile, []byte("not a certificate"), at)
+	time.Sleep(1100 * time.Millisecond)
+	if got := servedName(t, addr, ca.pool); got != "second" {
+		t.Errorf("served %q after a bad rotation, want second", got)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	cert, key := ca.issue(t, "server")
+	at := time.Now()
+	writeFile(t, certFile, cert, at)
+	writeFile(t, keyFile, key, at)
+	writeFile(t, caFile, ca.pem, at)
+	addr := serveTLS(t, ep.WithTLS(certFile, keyFile), ep.WithClientCA(caFile))
+
+	check := func(config *tls.Config) error {
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err = pb.NewHealthClient(conn).Check(ctx, &pb.HealthCheckRequest{})
+		return err
+	}
+
+	clientCert, clientKey := ca.issue(t, "envoy")
+	pair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check(&tls.Config{RootCAs: ca.pool, ServerName: "localhost", Certificates: []tls.Certificate{pair}}); err != nil {
+		t.Errorf("client with a certificate: %v", err)
+	}
+	if err := check(&tls.Config{RootCAs: ca.pool, ServerName: "localhost"}); err == nil {
+		t.Error("client without a certificate was accepted")
+	}
+
+	other := newTestCA(t)
+	otherCert, otherKey := other.issue(t, "intruder")
+	pair, err = tls.X509KeyPair(otherCert, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check(&tls.Config{RootCAs: ca.pool, ServerName: "localhost", Certificates: []tls.Certificate{pair}}); err == nil {
+		t.Error("client with a certificate from another CA was accepted")
+	}
+}
+
+func TestClientCARequiresCertificate(t *testing.T) {
+	if _, err := ep.NewServer(&ep.ProcessorFuncs{Name: "tls"}, ep.WithClientCA("ca.crt")); err == nil {
+		t.Error("client CA accepted without a server certificate")
+	}
+}
@@ -0,0 +1,156 @@
+package extproc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+const tracerName = "github.com/wrossmorrow/envoy-extproc-sdk-go"
+
+// WithTracerProvider creates stream and phase spans with tp instead of
+// the global otel.GetTracerProvider() (which is a no-op unless set).
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *serverConfig) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithPropagator extracts trace context from request headers with p. By
+// default W3C trace context (traceparent), baggage and B3 are extracted.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(c *serverConfig) {
+		c.propagator = p
+	}
+}
+
+func defaultPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(b3.New(), propagation.TraceContext{}, propagation.Baggage{})
+}
+
+func (s *GenericExtProcServer) tracerOrDefault() trace.Tracer {
+	if s.tracer == nil {
+		return otel.GetTracerProvider().Tracer(tracerName)
+	}
+	return s.tracer
+}
+
+func (s *GenericExtProcServer) propagatorOrDefault() propagation.TextMapPropagator {
+	if s.propagator == nil {
+		return defaultPropagator()
+	}
+	return s.propagator
+}
+
+// Context returns the context for the phase being processed, carrying the
+// phase's span so processors can create their own child spans. The
+// context is cancelled when the stream ends.
+func (rc *RequestContext) Context() context.Context {
+	if rc.ctx == nil {
+		return context.Background()
+	}
+	return rc.ctx
+}
+
+// startStreamSpan starts the stream span as a child of any trace context
+// found in the request headers. Streams that skip the request headers
+// phase get a root stream span when their first phase starts.
+func (s *GenericExtProcServer) startStreamSpan(rc *RequestContext, headers *corev3.HeaderMap) {
+	if rc.streamSpan != nil {
+		return
+	}
+
+	parent := rc.streamCtx
+	if headers != nil {
+		carrier := propagation.MapCarrier{}
+		for _, h := range headers.Headers {
+			val := h.Value
+			if len(h.RawValue) > 0 {
+				val = string(h.RawValue)
+			}
+			carrier[strings.ToLower(h.Key)] = val
+		}
+		parent = s.propagatorOrDefault().Extract(parent, carrier)
+	}
+
+	started := rc.Started
+	if started.IsZero() {
+		started = time.Now()
+	}
+	attrs := []attribute.KeyValue{attribute.String("extproc.processor", s.name)}
+	if rc.RequestID != "" {
+		attrs = append(attrs, attribute.String("extproc.request_id", rc.RequestID))
+	}
+	if rc.Method != "" {
+		attrs = append(attrs, attribute.String("http.request.method", rc.Method), attribute.String("url.path", rc.Path))
+	}
+
+	ctx, span := s.tracerOrDefault().Start(parent, "extproc.stream",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(started),
+		trace.WithAttributes(attrs...),
+	)
+	rc.streamCtx, rc.streamSpan, rc.ctx = ctx, span, ctx
+}
+
+// startPhaseSpan starts a child span of the stream for the current phase
+// and returns the time processing started.
+func (s *GenericExtProcServer) startPhaseSpan(rc *RequestContext) time.Time {
+	if rc.streamSpan == nil {
+		s.startStreamSpan(rc, nil)
+	}
+	rc.ctx, rc.phaseSpan = s.tracerOrDefault().Start(rc.streamCtx, "extproc."+PhaseName(rc.phase),
+		trace.WithAttributes(
+			attribute.String("extproc.processor", s.name),
+			attribute.String("extproc.phase", PhaseName(rc.phase)),
+			attribute.Bool("extproc.end_of_stream", rc.EndOfStream),
+		),
+	)
+	return time.Now()
+}
+
+// endPhaseSpan records the outcome of the phase and ends its span.
+func (s *GenericExtProcServer) endPhaseSpan(rc *RequestContext, resp *extprocv3.ProcessingResponse, err error) {
+	span := rc.phaseSpan
+	if span == nil {
+		return
+	}
+	if ir := resp.GetImmediateResponse(); ir != nil {
+		status := int64(ir.GetStatus().GetCode())
+		span.SetAttributes(attribute.Int64("extproc.immediate_response.status", status))
+		rc.streamSpan.SetAttributes(
+			attribute.Bool("extproc.cancelled", true),
+			attribute.Int64("extproc.immediate_response.status", status),
+		)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+	rc.ctx, rc.phaseSpan = rc.streamCtx, nil
+}
+
+// endStreamSpan ends the stream span (if one was started).
+func (s *GenericExtProcServer) endStreamSpan(rc *RequestContext, err error) {
+	span := rc.streamSpan
+	if span == nil {
+		return
+	}
+	span.SetAttributes(attribute.Int64("extproc.duration_ns", rc.Duration.Nanoseconds()))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,93 @@
+package extproc_test
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+const kTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func spanAttribute(span tracetest.SpanStub, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "traced",
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			return ctx.CancelRequest(403, nil, "")
+		},
+	}, ep.WithTracerProvider(tp))
+	h.Request("POST", "/things").
+		Header("x-request-id", "req-1").
+		Header("traceparent", kTraceParent).
+		Body("data").
+		Run().AssertImmediateResponse(403)
+
+	spans := exp.GetSpans()
+	var stream *tracetest.SpanStub
+	phases := map[string]tracetest.SpanStub{}
+	for i, span := range spans {
+		if span.Name == "extproc.stream" {
+			stream = &spans[i]
+		} else {
+			phases[span.Name] = span
+		}
+	}
+	if stream == nil {
+		t.Fatalf("no stream span in %d spans", len(spans))
+	}
+
+	if got := stream.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("stream trace id %s, want the traceparent's", got)
+	}
+	if got := stream.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("stream parent span id %s, want the traceparent's", got)
+	}
+	for key, want := range map[string]string{
+		"extproc.processor":   "traced",
+		"extproc.request_id":  "req-1",
+		"http.request.method": "POST",
+		"url.path":            "/things",
+	} {
+		if v, ok := spanAttribute(*stream, key); !ok || v.AsString() != want {
+			t.Errorf("stream attribute %s = %q, want %q", key, v.AsString(), want)
+		}
+	}
+	if v, ok := spanAttribute(*stream, "extproc.cancelled"); !ok || !v.AsBool() {
+		t.Error("stream span not marked cancelled")
+	}
+
+	for _, name := range []string{"extproc.request_headers", "extproc.request_body"} {
+		span, ok := phases[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.Parent.SpanID() != stream.SpanContext.SpanID() {
+			t.Errorf("%s span is not a child of the stream span", name)
+		}
+	}
+	if v, ok := spanAttribute(phases["extproc.request_body"], "extproc.immediate_response.status"); !ok || v.AsInt64() != 403 {
+		t.Errorf("request body span immediate response status %v, want 403", v.Emit())
+	}
+	if v, ok := spanAttribute(phases["extproc.request_body"], "extproc.end_of_stream"); !ok || !v.AsBool() {
+		t.Error("request body span end_of_stream not set")
+	}
+}
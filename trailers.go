@@ -0,0 +1,113 @@
+package extproc
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+)
+
+// trailerMutation holds trailer changes for one direction, made before
+// its trailers phase.
+type trailerMutation struct {
+	set    []*corev3.HeaderValue
+	remove []string
+}
+
+func (rc *RequestContext) trailerMutation(phase int) *trailerMutation {
+	switch phase {
+	case REQUEST_PHASE_RESPONSE_HEADERS, REQUEST_PHASE_RESPONSE_BODY, REQUEST_PHASE_RESPONSE_TRAILERS:
+		return &rc.responseTrailers
+	default:
+		return &rc.requestTrailers
+	}
+}
+
+var errTrailersSkipped = errors.New("trailers are not sent to the processor (trailer processing mode is not SEND)")
+
+// SetTrailer sets a trailer of the request or response, e.g. grpc-status
+// or a checksum computed over the body. The trailer only takes effect in
+// the trailers phase of the same direction: set before it (e.g. in body
+// phases), it is applied to the trailers when envoy sends that phase,
+// which requires the trailer processing mode to be SEND (envoy does not
+// apply the trailers field of earlier phase responses, though it is set
+// too). Returns an error if the processing mode is known to skip the
+// trailers phase.
+func (rc *RequestContext) SetTrailer(name string, hv HeaderValue) error {
+	if rc.isTrailersPhase() {
+		return rc.OverwriteHeader(name, hv)
+	}
+	if !rc.sendsTrailers() {
+		return errTrailersSkipped
+	}
+
+	value := hv.RawValue
+	if value == nil {
+		value = []byte(hv.Value)
+	}
+	trailer := &corev3.HeaderValue{Key: name, RawValue: value}
+
+	if rc.response.trailers == nil {
+		rc.response.trailers = &corev3.HeaderMap{}
+	}
+	rc.response.trailers.Headers = append(rc.response.trailers.Headers, trailer)
+
+	tm := rc.trailerMutation(rc.phase)
+	tm.remove = slices.DeleteFunc(tm.remove, func(r string) bool { return strings.EqualFold(r, name) })
+	tm.set = append(tm.set, trailer)
+	return nil
+}
+
+// RemoveTrailer removes a trailer of the request or response. As with
+// SetTrailer, the removal only takes effect in the trailers phase, and
+// an error is returned if the processing mode is known to skip it.
+func (rc *RequestContext) RemoveTrailer(name string) error {
+	if rc.isTrailersPhase() {
+		return rc.RemoveHeader(name)
+	}
+	if !rc.sendsTrailers() {
+		return errTrailersSkipped
+	}
+
+	named := func(h *corev3.HeaderValue) bool { return strings.EqualFold(h.Key, name) }
+	if rc.response.trailers != nil {
+		rc.response.trailers.Headers = slices.DeleteFunc(rc.response.trailers.Headers, named)
+	}
+
+	tm := rc.trailerMutation(rc.phase)
+	tm.set = slices.DeleteFunc(tm.set, named)
+	if !slices.Contains(tm.remove, name) {
+		tm.remove = append(tm.remove, name)
+	}
+	return nil
+}
+
+// sendsTrailers reports whether envoy sends the trailers phase of the
+// current phase's direction, as far as the processing mode is known.
+func (rc *RequestContext) sendsTrailers() bool {
+	if rc.mode == nil {
+		return true
+	}
+	mode := rc.mode.RequestTrailerMode
+	switch rc.phase {
+	case REQUEST_PHASE_RESPONSE_HEADERS, REQUEST_PHASE_RESPONSE_BODY:
+		mode = rc.mode.ResponseTrailerMode
+	}
+	return mode == filterv3.ProcessingMode_SEND
+}
+
+func (rc *RequestContext) isTrailersPhase() bool {
+	return rc.phase == REQUEST_PHASE_REQUEST_TRAILERS || rc.phase == REQUEST_PHASE_RESPONSE_TRAILERS
+}
+
+// applyTrailerMutation adds trailer changes made in earlier phases to the
+// trailers phase response.
+func (rc *RequestContext) applyTrailerMutation() {
+	tm := rc.trailerMutation(rc.phase)
+	for _, trailer := range tm.set {
+		rc.OverwriteHeader(trailer.Key, HeaderValue{RawValue: trailer.RawValue})
+	}
+	rc.RemoveHeaders(tm.remove)
+}
@@ -0,0 +1,86 @@
+package extproc_test
+
+import (
+	"testing"
+
+	filterv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+
+	ep "github.com/wrossmorrow/envoy-extproc-sdk-go"
+	"github.com/wrossmorrow/envoy-extproc-sdk-go/extproctest"
+)
+
+func TestTrailersFromBodyPhase(t *testing.T) {
+	var errs []error
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "trailers",
+		Options: &ep.ProcessingOptions{ProcessingMode: &filterv3.ProcessingMode{
+			RequestTrailerMode: filterv3.ProcessingMode_SEND,
+		}},
+		RequestBody: func(ctx *ep.RequestContext, body []byte) error {
+			errs = append(errs,
+				ctx.SetTrailer("x-checksum", ep.HeaderValue{RawValue: []byte("abc")}),
+				ctx.RemoveTrailer("x-debug"))
+			return ctx.ContinueRequest()
+		},
+	})
+	res := h.Request("POST", "/").Body("data").Trailer("x-checksum", "old").Trailer("x-debug", "1").Run()
+
+	res.AssertContinued()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("trailer change error %v", err)
+		}
+	}
+	// applied in the trailers phase
+	res.AssertHeader(ep.REQUEST_PHASE_REQUEST_TRAILERS, "x-checksum", "abc")
+	trailers := res.Upstream().Trailers
+	if trailers.Get("x-checksum") != "abc" || trailers.Has("x-debug") {
+		t.Errorf("upstream trailers %v, want x-checksum changed and x-debug removed", trailers.Fields())
+	}
+}
+
+func TestTrailersInTrailersPhase(t *testing.T) {
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "trailers",
+		ResponseTrailers: func(ctx *ep.RequestContext, trailers ep.Headers) error {
+			if trailers.Get("grpc-status") != "0" {
+				ctx.SetTrailer("grpc-message", ep.HeaderValue{RawValue: []byte("failed")})
+			}
+			return ctx.RemoveTrailer("x-internal")
+		},
+	})
+	res := h.Request("GET", "/").Response(200).Trailer("grpc-status", "2").Trailer("x-internal", "1").Run()
+
+	res.AssertContinued()
+	trailers := res.Downstream().Trailers
+	if trailers.Get("grpc-message") != "failed" || trailers.Has("x-internal") {
+		t.Errorf("downstream trailers %v, want grpc-message set and x-internal removed", trailers.Fields())
+	}
+}
+
+func TestTrailersSkippedByMode(t *testing.T) {
+	var setErr, removeErr error
+	h := extproctest.New(t, &ep.ProcessorFuncs{
+		Name: "trailers",
+		Options: &ep.ProcessingOptions{ProcessingMode: &filterv3.ProcessingMode{
+			RequestTrailerMode:  filterv3.ProcessingMode_SEND,
+			ResponseTrailerMode: filterv3.ProcessingMode_SKIP,
+		}},
+		RequestHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			if err := ctx.SetTrailer("x-request", ep.HeaderValue{RawValue: []byte("1")}); err != nil {
+				t.Errorf("request trailer error %v with request trailers sent", err)
+			}
+			return ctx.ContinueRequest()
+		},
+		ResponseHeaders: func(ctx *ep.RequestContext, headers ep.Headers) error {
+			setErr = ctx.SetTrailer("x-response", ep.HeaderValue{RawValue: []byte("1")})
+			removeErr = ctx.RemoveTrailer("x-response")
+			return ctx.ContinueRequest()
+		},
+	})
+	h.Request("GET", "/").Response(200).Run().AssertContinued()
+
+	if setErr == nil || removeErr == nil {
+		t.Errorf("trailer changes accepted with response trailers skipped: %v, %v", setErr, removeErr)
+	}
+}